@@ -0,0 +1,108 @@
+package typst
+
+import (
+	"fmt"
+	"strings"
+)
+
+// BibEntry is a bibliography entry, written to Typst in the Hayagriva
+// format. Only Key is required.
+type BibEntry struct {
+	Key       string // citation key, as in @key or #cite(<key>)
+	Type      string // Hayagriva entry type, e.g. "article", "book", "web"; defaults to "misc"
+	Title     string
+	Authors   []string // "Last, First" or "First Last"
+	Date      string   // "2024", "2024-05" or "2024-05-17"
+	Publisher string
+	Journal   string // title of the periodical an article appeared in
+	Volume    string
+	Issue     string
+	Pages     string // page range, e.g. "12-18"
+	URL       string
+	DOI       string
+}
+
+// hayagriva converts e to a Hayagriva entry, omitting empty fields.
+func (e BibEntry) hayagriva() map[string]any {
+	entry := map[string]any{"type": e.Type}
+	if e.Type == "" {
+		entry["type"] = "misc"
+	}
+	set := func(key, value string) {
+		if value != "" {
+			entry[key] = value
+		}
+	}
+	set("title", e.Title)
+	set("date", e.Date)
+	set("publisher", e.Publisher)
+	set("page-range", e.Pages)
+	set("url", e.URL)
+	if len(e.Authors) > 0 {
+		entry["author"] = e.Authors
+	}
+	if e.DOI != "" {
+		entry["serial-number"] = map[string]string{"doi": e.DOI}
+	}
+	if e.Journal != "" || e.Volume != "" || e.Issue != "" {
+		parent := map[string]any{"type": "periodical"}
+		for key, value := range map[string]string{"title": e.Journal, "volume": e.Volume, "issue": e.Issue} {
+			if value != "" {
+				parent[key] = value
+			}
+		}
+		entry["parent"] = parent
+	}
+	return entry
+}
+
+// WithBibliography makes bibliography data available at name for
+// #bibliography(name), without writing it to disk. The format follows the
+// extension: ".bib" for BibTeX, ".yml" or ".yaml" for Hayagriva.
+func WithBibliography(name string, data []byte) CompileOption {
+	return WithFile(name, data)
+}
+
+// WithBibliographyEntries makes entries available as a Hayagriva file at
+// name for #bibliography(name). A ".yml" extension is added if name has
+// none of ".yml" or ".yaml". An empty or duplicate key fails the
+// compilation.
+func WithBibliographyEntries(name string, entries ...BibEntry) CompileOption {
+	if !strings.HasSuffix(name, ".yml") && !strings.HasSuffix(name, ".yaml") {
+		name += ".yml"
+	}
+	library := make(map[string]any, len(entries))
+	var err error
+	for _, e := range entries {
+		if _, dup := library[e.Key]; err == nil && e.Key == "" {
+			err = fmt.Errorf("typst: bibliography %s: entry without a key", name)
+		} else if err == nil && dup {
+			err = fmt.Errorf("typst: bibliography %s: duplicate key %q", name, e.Key)
+		}
+		library[e.Key] = e.hayagriva()
+	}
+	if err != nil {
+		return func(cfg *compileConfig) {
+			if cfg.err == nil {
+				cfg.err = err
+			}
+		}
+	}
+	// JSON is valid YAML, so the encoded map is a Hayagriva file as is.
+	return WithFile(name, encodeData(library))
+}
+
+// WithCSLStyle makes a CSL style file available at name, for use with
+// #bibliography(..., style: name). name should end in ".csl".
+func WithCSLStyle(name string, data []byte) CompileOption {
+	return WithFile(name, data)
+}
+
+// CitationKey reports the cited key if d is the error of a citation whose
+// key could not be found: a #cite(<key>) key missing from the bibliography,
+// or an @key that is neither a label nor, in a document with a bibliography,
+// an entry. Other errors, including other errors in a #cite call and
+// unresolved references in a document without a bibliography, report false.
+func (d Diagnostic) CitationKey() (string, bool) {
+	return d.citationKey, d.citationKey != ""
+}
@@ -0,0 +1,116 @@
+package typst
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+var testEntries = []BibEntry{
+	{
+		Key:     "knuth84",
+		Type:    "article",
+		Title:   "Literate Programming",
+		Authors: []string{"Knuth, Donald E."},
+		Date:    "1984",
+		Journal: "The Computer Journal",
+		Volume:  "27",
+		Pages:   "97-111",
+	},
+	{Key: "typst", Type: "web", Title: "Typst", URL: "https://typst.app"},
+}
+
+func TestWithBibliographyEntries(t *testing.T) {
+	c := newTestCompiler(t)
+	source := []byte(`See @knuth84 and @typst.
+
+#bibliography("refs.yml")
+`)
+	doc, err := c.CompileBytes(source, WithBibliographyEntries("refs", testEntries...))
+	if err != nil {
+		t.Fatalf("compile with bibliography entries failed: %v", err)
+	}
+	defer doc.Close()
+
+	if !bytes.HasPrefix(doc.Bytes(), []byte("%PDF-")) {
+		t.Fatal("output does not look like a PDF")
+	}
+}
+
+func TestWithBibliography_bibtex(t *testing.T) {
+	c := newTestCompiler(t)
+	bib := []byte(`@book{tex, title = {The TeXbook}, author = {Knuth, Donald E.}, year = {1986}}`)
+	doc, err := c.CompileBytes([]byte("@tex\n#bibliography(\"refs.bib\")"), WithBibliography("refs.bib", bib))
+	if err != nil {
+		t.Fatalf("compile with BibTeX bibliography failed: %v", err)
+	}
+	defer doc.Close()
+}
+
+func TestWithBibliography_missingKey(t *testing.T) {
+	c := newTestCompiler(t)
+	source := []byte("Intro.\n\nSee @missing.\n#bibliography(\"refs.yml\")")
+	_, err := c.CompileBytes(source, WithBibliographyEntries("refs.yml", testEntries...))
+	var ce *CompileError
+	if !errors.As(err, &ce) {
+		t.Fatalf("expected CompileError, got %T: %v", err, err)
+	}
+	for _, d := range ce.Diagnostics {
+		if key, ok := d.CitationKey(); ok {
+			if key != "missing" || d.Line != 3 {
+				t.Fatalf("unexpected citation diagnostic: key %q, %+v", key, d)
+			}
+			return
+		}
+	}
+	t.Fatalf("no citation key diagnostic in %+v", ce.Diagnostics)
+}
+
+func TestWithBibliography_citeCall(t *testing.T) {
+	c := newTestCompiler(t)
+	source := []byte("#cite(<absent>, form: \"prose\")\n#bibliography(\"refs.yml\")")
+	_, err := c.CompileBytes(source, WithBibliographyEntries("refs.yml", testEntries...))
+	var ce *CompileError
+	if !errors.As(err, &ce) {
+		t.Fatalf("expected CompileError, got %T: %v", err, err)
+	}
+	for _, d := range ce.Diagnostics {
+		if key, ok := d.CitationKey(); ok && key == "absent" {
+			return
+		}
+	}
+	t.Fatalf("no citation key diagnostic in %+v", ce.Diagnostics)
+}
+
+func TestDiagnostic_CitationKey_otherErrors(t *testing.T) {
+	c := newTestCompiler(t)
+	for _, source := range []string{
+		"See @fig-missing.",
+		"#cite(<knuth84>, style: \"absent.csl\")\n#bibliography(\"refs.yml\")",
+	} {
+		_, err := c.CompileBytes([]byte(source), WithBibliographyEntries("refs.yml", testEntries...))
+		var ce *CompileError
+		if !errors.As(err, &ce) {
+			t.Fatalf("expected CompileError for %q, got %T: %v", source, err, err)
+		}
+		for _, d := range ce.Diagnostics {
+			if key, ok := d.CitationKey(); ok {
+				t.Fatalf("unexpected citation key %q for %q: %+v", key, source, d)
+			}
+		}
+	}
+}
+
+func TestWithBibliographyEntries_invalidKeys(t *testing.T) {
+	c := newTestCompiler(t)
+	source := []byte("#bibliography(\"refs.yml\")")
+	for _, entries := range [][]BibEntry{
+		{{Key: "a"}, {Title: "No key"}},
+		{{Key: "a"}, {Key: "b"}, {Key: "a"}},
+	} {
+		_, err := c.CompileBytes(source, WithBibliographyEntries("refs.yml", entries...))
+		if err == nil {
+			t.Fatalf("expected error for entries %+v", entries)
+		}
+	}
+}
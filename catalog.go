@@ -0,0 +1,81 @@
+package typst
+
+/*
+#include "typst_ffi.h"
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"runtime"
+	"sort"
+)
+
+// FontInfo describes a font face a [Compiler] can typeset with.
+type FontInfo struct {
+	Family    string       // family name, as used in #set text(font: ...)
+	Style     string       // "normal", "italic" or "oblique"
+	Weight    int          // 100 (thin) to 900 (black); 400 is regular
+	Stretch   float64      // width relative to normal, from 0.5 to 2
+	Monospace bool         // whether all glyphs have the same advance
+	Math      bool         // whether the face has an OpenType MATH table
+	Axes      []FontAxis   // variation axes; empty unless a variable font
+	Coverage  FontCoverage // the code points the face has glyphs for
+	Source    FontSource   // which font option supplied the face
+}
+
+// FontAxis is a variation axis of a variable font.
+type FontAxis struct {
+	Tag     string // four-letter axis tag, e.g. "wght" or "wdth"
+	Min     float64
+	Default float64
+	Max     float64
+}
+
+// FontCoverage summarizes the code points a font face covers.
+type FontCoverage struct {
+	Codepoints int       // number of covered code points
+	Ranges     [][2]rune // inclusive, ascending runs of covered code points
+}
+
+// Contains reports whether the face covers r.
+func (c FontCoverage) Contains(r rune) bool {
+	i := sort.Search(len(c.Ranges), func(i int) bool { return c.Ranges[i][1] >= r })
+	return i < len(c.Ranges) && c.Ranges[i][0] <= r
+}
+
+// FontSource identifies where a font face came from.
+type FontSource struct {
+	Bundled bool   // one of the fonts bundled with Typst
+	File    int    // for custom fonts, position among the supplied font files
+	Path    string // for custom fonts, the file's path or fs.FS name; empty for [WithFonts]
+	Index   int    // face index within a collection (.ttc/.otc)
+}
+
+// Fonts returns every font face the compiler can typeset with, bundled and
+// custom, in lookup order. Variable fonts are loaded to read their axes;
+// other faces stay unparsed. Returns nil after Close.
+func (c *Compiler) Fonts() []FontInfo {
+	if !c.acquire() {
+		return nil
+	}
+	defer c.release()
+	var fonts []FontInfo
+	if json.Unmarshal(takeBytes(C.typst_world_fonts(c.world)), &fonts) != nil {
+		return nil
+	}
+	return fonts
+}
+
+// HasFont reports whether the compiler has a font of the given family, as
+// #set text(font: family) would find it. Family names are matched
+// case-insensitively.
+func (c *Compiler) HasFont(family string) bool {
+	if family == "" || !c.acquire() {
+		return false
+	}
+	defer c.release()
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+	return C.typst_world_has_font(c.world, cString(&pinner, family)) != 0
+}
@@ -0,0 +1,69 @@
+package typst
+
+import "testing"
+
+func TestCompiler_Fonts(t *testing.T) {
+	c := newTestCompiler(t)
+
+	fonts := c.Fonts()
+	if len(fonts) == 0 {
+		t.Fatal("expected bundled fonts")
+	}
+	var serif, mono, math bool
+	for _, f := range fonts {
+		if !f.Source.Bundled {
+			t.Fatalf("unexpected custom font: %+v", f.Source)
+		}
+		switch {
+		case f.Family == "Libertinus Serif" && f.Style == "normal" && f.Weight == 400:
+			serif = f.Coverage.Contains('A') && f.Coverage.Contains('ä') && !f.Coverage.Contains('ༀ')
+		case f.Family == "DejaVu Sans Mono":
+			mono = mono || f.Monospace
+		case f.Math:
+			math = true
+		}
+	}
+	if !serif || !mono || !math {
+		t.Fatalf("missing expected fonts: serif=%v mono=%v math=%v", serif, mono, math)
+	}
+}
+
+func TestCompiler_HasFont(t *testing.T) {
+	c := newTestCompiler(t)
+	if !c.HasFont("Libertinus Serif") || !c.HasFont("libertinus serif") {
+		t.Fatal("expected bundled Libertinus Serif")
+	}
+	if c.HasFont("Comic Sans MS") {
+		t.Fatal("unexpected font")
+	}
+
+	bare, err := NewCompiler(WithoutBundledFonts())
+	if err != nil {
+		t.Fatalf("NewCompiler failed: %v", err)
+	}
+	defer bare.Close()
+	if bare.HasFont("Libertinus Serif") || len(bare.Fonts()) != 0 {
+		t.Fatal("bundled fonts present despite WithoutBundledFonts")
+	}
+}
+
+func TestCompiler_Fonts_afterClose(t *testing.T) {
+	c, err := NewCompiler()
+	if err != nil {
+		t.Fatalf("NewCompiler failed: %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 20 {
+			c.Fonts()
+			c.HasFont("Libertinus Serif")
+		}
+	}()
+	c.Close()
+	<-done
+
+	if c.Fonts() != nil || c.HasFont("Libertinus Serif") {
+		t.Fatal("expected no fonts after Close")
+	}
+}
@@ -0,0 +1,199 @@
+package typst
+
+/*
+#include "typst_ffi.h"
+*/
+import "C"
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+// Length is a Typst length: an absolute part in points plus a part relative
+// to the font size, as in 2pt + 1.5em.
+type Length struct {
+	Pt float64 // absolute part, in points
+	Em float64 // font-relative part, in em
+}
+
+// String formats l in Typst syntax.
+func (l Length) String() string {
+	switch {
+	case l.Em == 0:
+		return fmt.Sprintf("%gpt", l.Pt)
+	case l.Pt == 0:
+		return fmt.Sprintf("%gem", l.Em)
+	default:
+		return fmt.Sprintf("%gpt + %gem", l.Pt, l.Em)
+	}
+}
+
+// Eval evaluates Typst code, like `typst eval`, and returns its value as Go
+// data:
+//
+//	none                      → nil
+//	bool, str                 → bool, string
+//	int, float                → int64, float64
+//	array                     → []any
+//	dictionary, module        → map[string]any
+//	datetime                  → time.Time
+//	length                    → Length
+//
+// code uses code syntax: `(a: 1, b: 2.5)`, or a block such as
+// `{ import "config.typ": settings; settings }`. A module evaluates to a
+// dictionary of its top-level bindings, so `{ import "config.typ" as c; c }`
+// returns a whole Typst config file. Other values (content, colors, ...)
+// are returned in their `typst query` JSON form.
+//
+// Only [WithRoot], the package directory options ([WithPackageDir],
+// [WithPackagePaths], [WithPackageNamespace]), [WithPackageDownload],
+// [WithPackageRegistry] and [WithFile] apply to evaluation.
+func (c *Compiler) Eval(code string, opts ...CompileOption) (any, error) {
+	data, err := c.eval(code, opts)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("typst: decoding value: %w", err)
+	}
+	return evalValue(v), nil
+}
+
+// EvalInto evaluates Typst code like [Compiler.Eval] and stores the result
+// in the value pointed to by v, using encoding/json rules: dictionaries fill
+// structs and maps, datetimes fill [time.Time] and lengths fill [Length].
+func (c *Compiler) EvalInto(code string, v any, opts ...CompileOption) error {
+	value, err := c.Eval(code, opts...)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("typst: encoding value: %w", err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("typst: %w", err)
+	}
+	return nil
+}
+
+// eval runs the Rust evaluator and returns the JSON-encoded value.
+func (c *Compiler) eval(code string, opts []CompileOption) ([]byte, error) {
+	if !c.acquire() {
+		return nil, errors.New("typst: compiler is closed")
+	}
+	defer c.release()
+	if strings.TrimSpace(code) == "" {
+		return nil, &CompileError{Message: "empty source"}
+	}
+
+	cfg := newCompileConfig(opts)
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+	copts, err := cfg.cOptions(&pinner)
+	if err != nil {
+		return nil, err
+	}
+
+	src := unsafe.StringData(code)
+	result, err := cfg.run(func() C.TypstResult {
+		return C.typst_world_eval(
+			c.world,
+			(*C.uint8_t)(unsafe.Pointer(src)),
+			C.size_t(len(code)),
+			&copts,
+		)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return takeBytes(result), nil
+}
+
+// evalValue converts a decoded JSON value from the Rust evaluator into its Go
+// form. Every object the evaluator writes is a one-key envelope naming the
+// kind of value: "dict", "datetime", "length" or "other".
+func evalValue(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		return evalNumber(v)
+	case []any:
+		for i, e := range v {
+			v[i] = evalValue(e)
+		}
+		return v
+	case map[string]any:
+		switch {
+		case v["dict"] != nil:
+			dict, _ := v["dict"].(map[string]any)
+			for k, e := range dict {
+				dict[k] = evalValue(e)
+			}
+			return dict
+		case v["datetime"] != nil:
+			s, _ := v["datetime"].(string)
+			return parseDatetime(s)
+		case v["length"] != nil:
+			l, _ := v["length"].(map[string]any)
+			ptn, _ := l["pt"].(json.Number)
+			emn, _ := l["em"].(json.Number)
+			pt, _ := ptn.Float64()
+			em, _ := emn.Float64()
+			return Length{Pt: pt, Em: em}
+		default:
+			return jsonValue(v["other"])
+		}
+	default:
+		return v
+	}
+}
+
+// jsonValue resolves the numbers of a value in Typst's own JSON form.
+func jsonValue(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		return evalNumber(v)
+	case []any:
+		for i, e := range v {
+			v[i] = jsonValue(e)
+		}
+		return v
+	case map[string]any:
+		for k, e := range v {
+			v[k] = jsonValue(e)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// evalNumber returns an integer as int64 and any other number as float64.
+func evalNumber(n json.Number) any {
+	if i, err := n.Int64(); err == nil && !strings.ContainsAny(n.String(), ".eE") {
+		return i
+	}
+	f, _ := n.Float64()
+	return f
+}
+
+// parseDatetime parses a Typst datetime as formatted by the Rust side: a
+// date, a time, or both. Time-only values fall on January 1 of year 0.
+func parseDatetime(s string) time.Time {
+	for _, layout := range []string{"2006-01-02T15:04:05", time.DateOnly, time.TimeOnly} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
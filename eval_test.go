@@ -0,0 +1,103 @@
+package typst
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompiler_Eval(t *testing.T) {
+	c := newTestCompiler(t)
+	v, err := c.Eval(`(name: "report", pages: 3, scale: 1.5, tags: ("a", "b"), due: datetime(year: 2024, month: 5, day: 1), margin: 2cm + 1em, extra: none)`)
+	if err != nil {
+		t.Fatalf("Eval failed: %v", err)
+	}
+	dict, ok := v.(map[string]any)
+	if !ok {
+		t.Fatalf("expected map, got %T", v)
+	}
+	if dict["name"] != "report" || dict["pages"] != int64(3) || dict["scale"] != 1.5 || dict["extra"] != nil {
+		t.Fatalf("unexpected scalars: %v", dict)
+	}
+	if tags, ok := dict["tags"].([]any); !ok || len(tags) != 2 || tags[0] != "a" {
+		t.Fatalf("unexpected array: %v", dict["tags"])
+	}
+	if due := dict["due"]; due != time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC) {
+		t.Fatalf("unexpected datetime: %v", due)
+	}
+	margin, ok := dict["margin"].(Length)
+	if !ok || margin.Em != 1 || margin.Pt < 56.69 || margin.Pt > 56.70 {
+		t.Fatalf("unexpected length: %v", dict["margin"])
+	}
+}
+
+func TestCompiler_Eval_tagLikeDict(t *testing.T) {
+	c := newTestCompiler(t)
+	v, err := c.Eval(`(("$type"): "length", pt: 1, em: 2, datetime: "2024-05-01", length: 3)`)
+	if err != nil {
+		t.Fatalf("Eval failed: %v", err)
+	}
+	dict, ok := v.(map[string]any)
+	if !ok {
+		t.Fatalf("expected map, got %T", v)
+	}
+	if dict["$type"] != "length" || dict["pt"] != int64(1) || dict["datetime"] != "2024-05-01" || dict["length"] != int64(3) {
+		t.Fatalf("user dictionary decoded as a tagged value: %v", dict)
+	}
+}
+
+func TestEvalValue(t *testing.T) {
+	tests := []struct {
+		in   string
+		want any
+	}{
+		{`{"dict":{"dict":{"dict":{}}}}`, map[string]any{"dict": map[string]any{}}},
+		{`{"datetime":"2024-05-01"}`, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)},
+		{`{"length":{"pt":12,"em":0.5}}`, Length{Pt: 12, Em: 0.5}},
+		{`{"other":{"func":"text","size":3}}`, map[string]any{"func": "text", "size": int64(3)}},
+		{`[1, 2.5, "a", null]`, []any{int64(1), 2.5, "a", nil}},
+	}
+	for _, tt := range tests {
+		dec := json.NewDecoder(strings.NewReader(tt.in))
+		dec.UseNumber()
+		var v any
+		if err := dec.Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		if got := evalValue(v); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("evalValue(%s) = %#v, want %#v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCompiler_EvalInto(t *testing.T) {
+	c := newTestCompiler(t)
+	var cfg struct {
+		Title  string    `json:"title"`
+		Copies int       `json:"copies"`
+		Date   time.Time `json:"date"`
+		Gutter Length    `json:"gutter"`
+	}
+	config := []byte(`#let title = "Quarterly"
+#let copies = 2 * 5
+#let date = datetime(year: 2025, month: 1, day: 31)
+#let gutter = 12pt`)
+	err := c.EvalInto(`{ import "config.typ" as config; config }`, &cfg, WithFile("/config.typ", config))
+	if err != nil {
+		t.Fatalf("EvalInto failed: %v", err)
+	}
+	if cfg.Title != "Quarterly" || cfg.Copies != 10 || cfg.Date.Day() != 31 || cfg.Gutter != (Length{Pt: 12}) {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+}
+
+func TestCompiler_Eval_error(t *testing.T) {
+	c := newTestCompiler(t)
+	_, err := c.Eval(`1 + "a"`)
+	ce, ok := err.(*CompileError)
+	if !ok || len(ce.Diagnostics) == 0 {
+		t.Fatalf("expected CompileError with diagnostics, got %v", err)
+	}
+}
@@ -0,0 +1,299 @@
+package typst
+
+/*
+#include "typst_ffi.h"
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// fontExtensions are the file extensions picked up when scanning font
+// directories: TrueType/OpenType fonts and collections.
+var fontExtensions = []string{".ttf", ".otf", ".ttc", ".otc"}
+
+// fontFile is a font supplied at construction: in-memory data, or a path
+// the Rust side reads when data is nil. The name identifies it in warnings.
+type fontFile struct {
+	name string
+	data []byte
+}
+
+// fontGlob is a [WithFontFS] request, resolved in [NewCompiler].
+type fontGlob struct {
+	fsys    fs.FS
+	pattern string
+}
+
+// WithFontFiles loads the font files at the given paths. Unlike
+// [WithFonts], the files are memory-mapped rather than copied, and each face
+// is parsed only when a document first uses it, so large fonts (e.g. CJK)
+// cost little until needed. The files must not be modified while the
+// Compiler is open. Unreadable and broken files are skipped and reported by
+// [Compiler.Warnings].
+func WithFontFiles(paths ...string) CompilerOption {
+	return func(cfg *compilerConfig) {
+		for _, path := range paths {
+			cfg.addFontPath(path)
+		}
+	}
+}
+
+// WithFontDirs loads every font file (.ttf, .otf, .ttc, .otc) found in the
+// given directories and their subdirectories. Collections contribute all
+// their faces. Files are loaded lazily, as with [WithFontFiles].
+// Unreadable directories and broken files are skipped and reported by
+// [Compiler.Warnings].
+func WithFontDirs(dirs ...string) CompilerOption {
+	return func(cfg *compilerConfig) {
+		for _, dir := range dirs {
+			cfg.scanFontDir(dir, true)
+		}
+	}
+}
+
+// WithFontFS loads the font files in fsys matching pattern, in [fs.Glob]
+// syntax. It suits fonts embedded with embed.FS:
+//
+//	//go:embed fonts
+//	var fontFS embed.FS
+//
+//	c, err := typst.NewCompiler(typst.WithFontFS(fontFS, "fonts/*.ttf"))
+//
+// Files that cannot be read or parsed are skipped and reported by
+// [Compiler.Warnings]; a malformed pattern fails [NewCompiler].
+func WithFontFS(fsys fs.FS, pattern string) CompilerOption {
+	return func(cfg *compilerConfig) {
+		cfg.fontFS = append(cfg.fontFS, fontGlob{fsys: fsys, pattern: pattern})
+	}
+}
+
+// WithSystemFonts loads the fonts installed on the machine, from the
+// platform's font directories (see [SystemFontDirs]). Directories that do
+// not exist are ignored.
+func WithSystemFonts() CompilerOption {
+	return func(cfg *compilerConfig) {
+		for _, dir := range SystemFontDirs() {
+			cfg.scanFontDir(dir, false)
+		}
+	}
+}
+
+// WithoutBundledFonts leaves out the fonts bundled with Typst (Libertinus
+// Serif, New Computer Modern, DejaVu Sans Mono), so that only the fonts
+// supplied with the other font options exist. A misspelled family can then
+// no longer fall back to a bundled font. Math typesetting needs an OpenType
+// math font, such as New Computer Modern Math, among the supplied fonts.
+func WithoutBundledFonts() CompilerOption {
+	return func(cfg *compilerConfig) {
+		cfg.noBundledFonts = true
+	}
+}
+
+// WithFontFallback sets whether text may fall back to other fonts for
+// characters the selected font families do not cover. Typst allows it by
+// default. With fallback disabled, such characters are drawn as tofu and
+// reported by [Document.MissingGlyphs]. Documents can still override the
+// setting with #set text(fallback: ...).
+func WithFontFallback(enabled bool) CompilerOption {
+	return func(cfg *compilerConfig) {
+		cfg.fallback = &enabled
+	}
+}
+
+// SystemFontDirs returns the directories fonts are installed in on this
+// platform, in lookup order.
+//
+// On Linux and other Unix systems: $XDG_DATA_HOME/fonts (default
+// ~/.local/share/fonts), ~/.fonts and fonts under each $XDG_DATA_DIRS
+// entry (default /usr/local/share and /usr/share).
+// On macOS: ~/Library/Fonts, /Library/Fonts and /System/Library/Fonts.
+// On Windows: %WINDIR%\Fonts and the per-user font directory.
+func SystemFontDirs() []string {
+	home, _ := os.UserHomeDir()
+	var dirs []string
+	switch runtime.GOOS {
+	case "darwin":
+		if home != "" {
+			dirs = append(dirs, filepath.Join(home, "Library", "Fonts"))
+		}
+		dirs = append(dirs, "/Library/Fonts", "/System/Library/Fonts")
+	case "windows":
+		if windir := os.Getenv("WINDIR"); windir != "" {
+			dirs = append(dirs, filepath.Join(windir, "Fonts"))
+		}
+		if local := os.Getenv("LOCALAPPDATA"); local != "" {
+			dirs = append(dirs, filepath.Join(local, "Microsoft", "Windows", "Fonts"))
+		}
+	default:
+		dataHome := os.Getenv("XDG_DATA_HOME")
+		if dataHome == "" && home != "" {
+			dataHome = filepath.Join(home, ".local", "share")
+		}
+		if dataHome != "" {
+			dirs = append(dirs, filepath.Join(dataHome, "fonts"))
+		}
+		if home != "" {
+			dirs = append(dirs, filepath.Join(home, ".fonts"))
+		}
+		dataDirs := os.Getenv("XDG_DATA_DIRS")
+		if dataDirs == "" {
+			dataDirs = "/usr/local/share:/usr/share"
+		}
+		for _, dir := range filepath.SplitList(dataDirs) {
+			if dir != "" {
+				dirs = append(dirs, filepath.Join(dir, "fonts"))
+			}
+		}
+	}
+	return dirs
+}
+
+// scanFontDir adds the font files under dir. A missing dir is reported as a
+// warning only if explicit is set.
+func (cfg *compilerConfig) scanFontDir(dir string, explicit bool) {
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			cfg.warnings = append(cfg.warnings, fmt.Sprintf("skipping %s: %v", path, err))
+			return nil
+		}
+		if d.IsDir() || !slices.Contains(fontExtensions, strings.ToLower(filepath.Ext(path))) {
+			return nil
+		}
+		cfg.addFontPath(path)
+		return nil
+	})
+	if err != nil && (explicit || !os.IsNotExist(err)) {
+		cfg.warnings = append(cfg.warnings, fmt.Sprintf("skipping font directory %s: %v", dir, err))
+	}
+}
+
+// addFontPath adds a font file on disk, once.
+func (cfg *compilerConfig) addFontPath(path string) {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if !slices.ContainsFunc(cfg.fonts, func(f fontFile) bool { return f.data == nil && f.name == path }) {
+		cfg.fonts = append(cfg.fonts, fontFile{name: path})
+	}
+}
+
+// readFontFS reads the fonts requested with [WithFontFS] and returns the
+// warnings collected so far.
+func (cfg *compilerConfig) readFontFS() ([]string, error) {
+	for _, g := range cfg.fontFS {
+		matches, err := fs.Glob(g.fsys, g.pattern)
+		if err != nil {
+			return nil, fmt.Errorf("typst: font pattern %q: %w", g.pattern, err)
+		}
+		for _, name := range matches {
+			data, err := fs.ReadFile(g.fsys, name)
+			if err != nil {
+				if info, statErr := fs.Stat(g.fsys, name); statErr == nil && info.IsDir() {
+					continue
+				}
+				cfg.warnings = append(cfg.warnings, fmt.Sprintf("skipping font %s: %v", name, err))
+				continue
+			}
+			if len(data) == 0 {
+				cfg.warnings = append(cfg.warnings, fmt.Sprintf("skipping font %s: empty file", name))
+				continue
+			}
+			cfg.fonts = append(cfg.fonts, fontFile{name: name, data: data})
+		}
+	}
+	return cfg.warnings, nil
+}
+
+// cFontFiles converts font files to a pinned C array.
+func cFontFiles(pinner *runtime.Pinner, fonts []fontFile) (*C.TypstFile, C.size_t) {
+	if len(fonts) == 0 {
+		return nil, 0
+	}
+	files := make([]C.TypstFile, len(fonts))
+	for i, f := range fonts {
+		files[i].path = cString(pinner, f.name)
+		files[i].data = cBytes(pinner, f.data)
+	}
+	pinner.Pin(&files[0])
+	return &files[0], C.size_t(len(files))
+}
+
+// AddFonts adds fonts to the compiler. It takes the font options
+// ([WithFonts], [WithFontFiles], [WithFontDirs], [WithFontFS] and
+// [WithSystemFonts]); any other option is an error. Compilations already
+// running keep the fonts they started with; later ones see the new fonts.
+// Files that cannot be read or parsed are skipped and reported by
+// [Compiler.Warnings].
+func (c *Compiler) AddFonts(opts ...CompilerOption) error {
+	if !c.acquire() {
+		return errors.New("typst: compiler is closed")
+	}
+	defer c.release()
+	var cfg compilerConfig
+	for _, o := range opts {
+		o(&cfg)
+	}
+	if cfg.globals != nil || cfg.styles != nil || cfg.noBundledFonts || cfg.fallback != nil || cfg.fontSet != nil {
+		return errors.New("typst: AddFonts accepts only font options")
+	}
+
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+
+	warnings, err := cfg.readFontFS()
+	if err != nil {
+		return err
+	}
+	fonts, count := cFontFiles(&pinner, cfg.fonts)
+	var rustWarnings []string
+	if err := json.Unmarshal(takeBytes(C.typst_world_add_fonts(c.world, fonts, count)), &rustWarnings); err == nil {
+		warnings = append(warnings, rustWarnings...)
+	}
+
+	c.mu.Lock()
+	c.warnings = append(c.warnings, warnings...)
+	c.mu.Unlock()
+	return nil
+}
+
+// RemoveFonts removes every face of the given families (case-insensitive),
+// bundled or custom, and returns the number of faces removed. Compilations
+// already running keep the fonts they started with; later ones fall back to
+// the remaining fonts where a document asks for a removed family.
+func (c *Compiler) RemoveFonts(families ...string) int {
+	if len(families) == 0 || !c.acquire() {
+		return 0
+	}
+	defer c.release()
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+
+	cfamilies := make([]C.TypstSlice, len(families))
+	for i, family := range families {
+		cfamilies[i] = cString(&pinner, family)
+	}
+	pinner.Pin(&cfamilies[0])
+	return int(C.typst_world_remove_fonts(c.world, &cfamilies[0], C.size_t(len(cfamilies))))
+}
+
+// Warnings returns the problems found while loading fonts, at creation or
+// by [Compiler.AddFonts], such as font files that could not be read or
+// parsed and were skipped.
+func (c *Compiler) Warnings() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.warnings)
+}
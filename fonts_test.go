@@ -0,0 +1,187 @@
+package typst
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+func TestWithFontDirs_brokenFile(t *testing.T) {
+	dir := t.TempDir()
+	broken := filepath.Join(dir, "sub", "Broken.TTF")
+	os.MkdirAll(filepath.Dir(broken), 0o755)
+	os.WriteFile(broken, []byte("not a font"), 0o644)
+	os.WriteFile(filepath.Join(dir, "README.txt"), []byte("ignored"), 0o644)
+
+	c, err := NewCompiler(WithFontDirs(dir, filepath.Join(dir, "missing")))
+	if err != nil {
+		t.Fatalf("NewCompiler failed: %v", err)
+	}
+	defer c.Close()
+
+	warnings := c.Warnings()
+	if len(warnings) != 2 {
+		t.Fatalf("expected 2 warnings, got %q", warnings)
+	}
+	if !slices.ContainsFunc(warnings, func(w string) bool { return strings.Contains(w, "Broken.TTF") }) {
+		t.Fatalf("no warning for the broken font: %q", warnings)
+	}
+	if !slices.ContainsFunc(warnings, func(w string) bool { return strings.Contains(w, "missing") }) {
+		t.Fatalf("no warning for the missing directory: %q", warnings)
+	}
+}
+
+func TestWithFontFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"fonts/Broken.ttf": {Data: []byte("not a font")},
+		"fonts/notes.txt":  {Data: []byte("ignored")},
+	}
+	c, err := NewCompiler(WithFontFS(fsys, "fonts/*.ttf"))
+	if err != nil {
+		t.Fatalf("NewCompiler failed: %v", err)
+	}
+	defer c.Close()
+	if w := c.Warnings(); len(w) != 1 || !strings.Contains(w[0], "fonts/Broken.ttf") {
+		t.Fatalf("unexpected warnings: %q", w)
+	}
+
+	if _, err := NewCompiler(WithFontFS(fsys, "fonts/[")); err == nil {
+		t.Fatal("expected error for malformed pattern")
+	}
+}
+
+func TestSystemFontDirs(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("XDG directories are Linux-specific")
+	}
+	t.Setenv("XDG_DATA_HOME", "/home/u/.data")
+	t.Setenv("XDG_DATA_DIRS", "/opt/share:/usr/share")
+
+	dirs := SystemFontDirs()
+	for _, want := range []string{"/home/u/.data/fonts", "/opt/share/fonts", "/usr/share/fonts"} {
+		if !slices.Contains(dirs, want) {
+			t.Errorf("SystemFontDirs() = %q, missing %s", dirs, want)
+		}
+	}
+}
+
+func TestWithFontFiles(t *testing.T) {
+	dir := t.TempDir()
+	broken := filepath.Join(dir, "broken.otf")
+	os.WriteFile(broken, []byte("not a font"), 0o644)
+
+	c, err := NewCompiler(WithFontFiles(broken, broken, filepath.Join(dir, "missing.ttf")))
+	if err != nil {
+		t.Fatalf("NewCompiler failed: %v", err)
+	}
+	defer c.Close()
+	// The duplicate path is loaded once.
+	if w := c.Warnings(); len(w) != 2 {
+		t.Fatalf("expected 2 warnings, got %q", w)
+	}
+
+	doc, err := c.CompileBytes([]byte("Bundled fonts still work."))
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	doc.Close()
+}
+
+func TestWithoutBundledFonts(t *testing.T) {
+	c, err := NewCompiler(WithoutBundledFonts())
+	if err != nil {
+		t.Fatalf("NewCompiler failed: %v", err)
+	}
+	defer c.Close()
+	if w := c.Warnings(); len(w) != 1 || !strings.Contains(w[0], "no fonts") {
+		t.Fatalf("expected a no-fonts warning, got %q", w)
+	}
+}
+
+func TestWithFontFallback(t *testing.T) {
+	c, err := NewCompiler(WithFontFallback(false))
+	if err != nil {
+		t.Fatalf("NewCompiler failed: %v", err)
+	}
+	defer c.Close()
+
+	results, err := c.Query(`#context [#metadata(text.fallback) <fallback>]`, "<fallback>")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(results) != 1 || !strings.Contains(string(results[0]), `"value":false`) {
+		t.Fatalf("fallback not disabled: %s", results)
+	}
+}
+
+func TestCompiler_AddFonts(t *testing.T) {
+	c := newTestCompiler(t)
+	fsys := fstest.MapFS{"Broken.ttf": {Data: []byte("not a font")}}
+	if err := c.AddFonts(WithFontFS(fsys, "*.ttf")); err != nil {
+		t.Fatalf("AddFonts failed: %v", err)
+	}
+	if w := c.Warnings(); len(w) != 1 || !strings.Contains(w[0], "Broken.ttf") {
+		t.Fatalf("unexpected warnings: %q", w)
+	}
+	if err := c.AddFonts(WithStyles(`#set text(size: 12pt)`)); err == nil {
+		t.Fatal("expected error for a non-font option")
+	}
+}
+
+func TestCompiler_RemoveFonts(t *testing.T) {
+	c := newTestCompiler(t)
+	if n := c.RemoveFonts("dejavu sans mono", "Comic Sans MS"); n == 0 {
+		t.Fatal("no faces removed")
+	}
+	if c.HasFont("DejaVu Sans Mono") || !c.HasFont("Libertinus Serif") {
+		t.Fatal("wrong fonts removed")
+	}
+	if n := c.RemoveFonts("DejaVu Sans Mono"); n != 0 {
+		t.Fatalf("removed %d faces twice", n)
+	}
+
+	doc, err := c.CompileBytes([]byte("`code` and text"), WithLayout())
+	if err != nil {
+		t.Fatalf("CompileBytes failed: %v", err)
+	}
+	defer doc.Close()
+	for _, f := range doc.FontsUsed() {
+		if f.Family == "DejaVu Sans Mono" {
+			t.Fatal("removed font still used")
+		}
+	}
+}
+
+func TestCompiler_fontChangesRaceClose(t *testing.T) {
+	c, err := NewCompiler()
+	if err != nil {
+		t.Fatalf("NewCompiler failed: %v", err)
+	}
+	fsys := fstest.MapFS{"Broken.ttf": {Data: []byte("not a font")}}
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 20 {
+				c.AddFonts(WithFontFS(fsys, "*.ttf"))
+				c.RemoveFonts("Comic Sans MS")
+			}
+		}()
+	}
+	c.Close()
+	wg.Wait()
+
+	if err := c.AddFonts(WithFontFS(fsys, "*.ttf")); err == nil {
+		t.Fatal("expected AddFonts to fail after Close")
+	}
+	if n := c.RemoveFonts("Libertinus Serif"); n != 0 {
+		t.Fatalf("RemoveFonts removed %d faces after Close", n)
+	}
+}
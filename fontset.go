@@ -0,0 +1,127 @@
+package typst
+
+/*
+#include "typst_ffi.h"
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"errors"
+	"runtime"
+	"slices"
+	"sync"
+)
+
+// FontSet is a set of fonts loaded once and shared by several compilers,
+// e.g. one [Compiler] per CPU. The font data and the font index exist once,
+// while each compiler keeps its own caches:
+//
+//	fonts, err := typst.NewFontSet(typst.WithFontFiles("Inter-Regular.ttf", "Inter-Italic.ttf"))
+//	defer fonts.Close()
+//	for range runtime.NumCPU() {
+//		c, err := typst.NewCompiler(typst.WithFontSet(fonts))
+//		...
+//	}
+//
+// A FontSet is reference-counted: compilers created with it keep the fonts
+// alive, so it may be closed as soon as they exist.
+type FontSet struct {
+	handle   *C.TypstFontSet
+	once     sync.Once    // ensures free() runs at most once
+	life     sync.RWMutex // read-held while handle is in use; free() takes it to write closed
+	closed   bool         // guarded by life
+	warnings []string     // problems found while loading the fonts
+}
+
+// NewFontSet loads a font set. It takes the font options ([WithFonts],
+// [WithFontFiles], [WithFontDirs], [WithFontFS], [WithSystemFonts] and
+// [WithoutBundledFonts]); any other option is an error. Bundled fonts are
+// included unless [WithoutBundledFonts] is given.
+func NewFontSet(opts ...CompilerOption) (*FontSet, error) {
+	var cfg compilerConfig
+	for _, o := range opts {
+		o(&cfg)
+	}
+	if cfg.globals != nil || cfg.styles != nil || cfg.fallback != nil || cfg.fontSet != nil {
+		return nil, errors.New("typst: NewFontSet accepts only font options")
+	}
+
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+
+	warnings, err := cfg.readFontFS()
+	if err != nil {
+		return nil, err
+	}
+	fonts, count := cFontFiles(&pinner, cfg.fonts)
+	var noBundled C.int32_t
+	if cfg.noBundledFonts {
+		noBundled = 1
+	}
+	handle := C.typst_font_set_new(fonts, count, noBundled)
+
+	var rustWarnings []string
+	if err := json.Unmarshal(takeBytes(C.typst_font_set_warnings(handle)), &rustWarnings); err == nil {
+		warnings = append(warnings, rustWarnings...)
+	}
+
+	s := &FontSet{handle: handle, warnings: warnings}
+	runtime.SetFinalizer(s, (*FontSet).free)
+	return s, nil
+}
+
+// WithFontSet makes the compiler use the fonts of set instead of the bundled
+// fonts, without copying them. [WithoutBundledFonts] has no effect then;
+// fonts given with the other font options are added on top, for this
+// compiler only. [NewCompiler] fails if set is closed.
+func WithFontSet(set *FontSet) CompilerOption {
+	return func(cfg *compilerConfig) {
+		cfg.fontSet = set
+	}
+}
+
+// Warnings returns the problems found while loading the set, such as font
+// files that could not be read or parsed and were skipped.
+func (s *FontSet) Warnings() []string {
+	return slices.Clone(s.warnings)
+}
+
+// Close releases the font set, after waiting for [NewCompiler] calls
+// already using it to finish. Compilers created with it keep their fonts.
+// Close is idempotent.
+func (s *FontSet) Close() error {
+	s.free()
+	return nil
+}
+
+// acquire marks a use of the Rust font set as in progress, so that Close
+// waits for it. It reports false if the set is closed; otherwise the
+// caller must call release when done with the handle.
+func (s *FontSet) acquire() bool {
+	s.life.RLock()
+	if s.closed {
+		s.life.RUnlock()
+		return false
+	}
+	return true
+}
+
+// release ends a use started with acquire.
+func (s *FontSet) release() {
+	s.life.RUnlock()
+}
+
+// free releases the Rust font set. Idempotent via sync.Once.
+func (s *FontSet) free() {
+	s.once.Do(func() {
+		s.life.Lock()
+		defer s.life.Unlock()
+		if s.handle != nil {
+			C.typst_font_set_free(s.handle)
+		}
+		s.handle = nil
+		s.closed = true
+		runtime.SetFinalizer(s, nil)
+	})
+}
@@ -0,0 +1,77 @@
+package typst
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFontSet(t *testing.T) {
+	fsys := fstest.MapFS{"Broken.ttf": {Data: []byte("not a font")}}
+	set, err := NewFontSet(WithFontFS(fsys, "*.ttf"))
+	if err != nil {
+		t.Fatalf("NewFontSet failed: %v", err)
+	}
+	if w := set.Warnings(); len(w) != 1 || !strings.Contains(w[0], "Broken.ttf") {
+		t.Fatalf("unexpected warnings: %q", w)
+	}
+
+	var compilers []*Compiler
+	for range 2 {
+		c, err := NewCompiler(WithFontSet(set))
+		if err != nil {
+			t.Fatalf("NewCompiler failed: %v", err)
+		}
+		defer c.Close()
+		compilers = append(compilers, c)
+	}
+	// The compilers keep the fonts alive.
+	set.Close()
+
+	// Removing fonts from one compiler leaves the other and the set alone.
+	compilers[0].RemoveFonts("DejaVu Sans Mono")
+	if compilers[0].HasFont("DejaVu Sans Mono") || !compilers[1].HasFont("DejaVu Sans Mono") {
+		t.Fatal("font removal leaked between compilers")
+	}
+	for _, c := range compilers {
+		doc, err := c.CompileBytes([]byte("= Shared fonts"))
+		if err != nil {
+			t.Fatalf("CompileBytes failed: %v", err)
+		}
+		doc.Close()
+	}
+
+	if _, err := NewCompiler(WithFontSet(set)); err == nil {
+		t.Fatal("expected error for a closed font set")
+	}
+	if _, err := NewFontSet(WithGlobal("x", 1)); err == nil {
+		t.Fatal("expected error for a non-font option")
+	}
+}
+
+func TestFontSet_closeRacesNewCompiler(t *testing.T) {
+	set, err := NewFontSet()
+	if err != nil {
+		t.Fatalf("NewFontSet failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 5 {
+				// Either the compiler gets the fonts or the set is closed.
+				if c, err := NewCompiler(WithFontSet(set)); err == nil {
+					if !c.HasFont("Libertinus Serif") {
+						t.Error("compiler created during Close lacks the set's fonts")
+					}
+					c.Close()
+				}
+			}
+		}()
+	}
+	set.Close()
+	wg.Wait()
+}
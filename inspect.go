@@ -0,0 +1,297 @@
+package typst
+
+/*
+#include "typst_ffi.h"
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"runtime"
+	"strings"
+	"unicode/utf8"
+)
+
+// WithLayout keeps the laid-out document, with every frame and glyph, until
+// the [Document] is closed, so that [Document.Text], [Document.Texts],
+// [Document.Locate], [Document.Links], [Document.FontsUsed] and
+// [Document.MissingGlyphs] can read it.
+// Without it only the PDF, metadata, pages and outline are kept, which
+// takes far less memory for large documents.
+func WithLayout() CompileOption {
+	return func(c *compileConfig) {
+		c.layout = true
+	}
+}
+
+// inspect runs a Rust document accessor and decodes its JSON result into v.
+// It reports false if the document is closed or the accessor failed, e.g.
+// because the layout was not kept.
+func (d *Document) inspect(accessor func(*C.TypstDocument) C.TypstResult, v any) bool {
+	if d.closed || d.handle == nil {
+		return false
+	}
+	data := takeBytes(accessor(d.handle))
+	return json.Unmarshal(data, v) == nil
+}
+
+// Metadata returns the effective document metadata: the document's own
+// #set document(...) values merged with any [WithMetadata] overrides.
+// Returns the zero value after Close.
+func (d *Document) Metadata() Metadata {
+	var w metadataWire
+	if !d.inspect(func(h *C.TypstDocument) C.TypstResult { return C.typst_document_metadata(h) }, &w) {
+		return Metadata{}
+	}
+	return w.metadata()
+}
+
+// PageInfo describes one page of a compiled document.
+type PageInfo struct {
+	Index  int     // 0-based position in the document
+	Number int     // logical page number, as counted by Typst's page counter
+	Label  string  // page number formatted by the page's numbering pattern, e.g. "iv"; empty without one
+	Width  float64 // in points
+	Height float64 // in points
+}
+
+// DocumentInfo summarizes a compiled document.
+type DocumentInfo struct {
+	Metadata
+	PageCount int
+}
+
+// PageCount returns the number of pages. Returns 0 after Close.
+func (d *Document) PageCount() int {
+	if d.closed || d.handle == nil {
+		return 0
+	}
+	return int(C.typst_document_page_count(d.handle))
+}
+
+// Pages returns an iterator over the pages of the document.
+// Yields nothing after Close.
+func (d *Document) Pages() iter.Seq[PageInfo] {
+	return func(yield func(PageInfo) bool) {
+		var pages []struct {
+			Number int     `json:"number"`
+			Label  string  `json:"label"`
+			Width  float64 `json:"width"`
+			Height float64 `json:"height"`
+		}
+		if !d.inspect(func(h *C.TypstDocument) C.TypstResult { return C.typst_document_pages(h) }, &pages) {
+			return
+		}
+		for i, p := range pages {
+			info := PageInfo{Index: i, Number: p.Number, Label: p.Label, Width: p.Width, Height: p.Height}
+			if !yield(info) {
+				return
+			}
+		}
+	}
+}
+
+// Info returns the document metadata together with the page count.
+func (d *Document) Info() DocumentInfo {
+	return DocumentInfo{Metadata: d.Metadata(), PageCount: d.PageCount()}
+}
+
+// Position is a point on a page of a compiled document.
+type Position struct {
+	Page int     // 0-based page index
+	X    float64 // points from the left edge of the page
+	Y    float64 // points from the top edge of the page
+}
+
+// OutlineEntry is a heading in the document outline.
+type OutlineEntry struct {
+	Level    int      // heading level, 1 for top-level headings
+	Title    string   // plain text of the heading body
+	Label    string   // label attached to the heading, without angle brackets; empty if none
+	Position Position // where the heading starts
+	Children []OutlineEntry
+}
+
+// Outline returns the heading tree of the document: each heading holds the
+// following headings of deeper level as children. Returns nil after Close.
+func (d *Document) Outline() []OutlineEntry {
+	var flat []OutlineEntry
+	if !d.inspect(func(h *C.TypstDocument) C.TypstResult { return C.typst_document_outline(h) }, &flat) {
+		return nil
+	}
+	return nestOutline(flat)
+}
+
+// nestOutline turns headings in document order into a tree by level.
+func nestOutline(flat []OutlineEntry) []OutlineEntry {
+	var roots []OutlineEntry
+	// path holds pointers to the current chain of open headings; each
+	// pointer addresses an element of its parent's Children (or roots).
+	var path []*OutlineEntry
+	for _, e := range flat {
+		for len(path) > 0 && path[len(path)-1].Level >= e.Level {
+			path = path[:len(path)-1]
+		}
+		siblings := &roots
+		if len(path) > 0 {
+			siblings = &path[len(path)-1].Children
+		}
+		*siblings = append(*siblings, e)
+		path = append(path, &(*siblings)[len(*siblings)-1])
+	}
+	return roots
+}
+
+// Locate returns the position of every element carrying label, in document
+// order: where it starts on the page, in points from the top-left corner.
+// label may be given with or without angle brackets ("sig" or "<sig>").
+// Requires [WithLayout]. Returns nil if no element carries the label, without
+// the layout, or after Close.
+func (d *Document) Locate(label string) []Position {
+	label = strings.TrimSuffix(strings.TrimPrefix(label, "<"), ">")
+	if label == "" {
+		return nil
+	}
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+	clabel := cString(&pinner, label)
+
+	var positions []Position
+	if !d.inspect(func(h *C.TypstDocument) C.TypstResult { return C.typst_document_locate(h, clabel) }, &positions) {
+		return nil
+	}
+	if len(positions) == 0 {
+		return nil
+	}
+	return positions
+}
+
+// PageText is the plain text of one page.
+type PageText struct {
+	Page  int    // 0-based page index
+	Text  string // lines in reading order, each terminated by "\n"
+	Words int    // whitespace-separated words
+	Chars int    // Unicode code points, excluding line breaks
+}
+
+// Text extracts the plain text of the page at a 0-based index from the
+// laid-out text runs, in reading order. Requires [WithLayout].
+func (d *Document) Text(page int) (PageText, error) {
+	if d.closed || d.handle == nil {
+		return PageText{}, errors.New("typst: text on closed document")
+	}
+	if page < 0 || page >= d.PageCount() {
+		return PageText{}, fmt.Errorf("typst: page %d out of range", page)
+	}
+	result := C.typst_document_text(d.handle, C.size_t(page))
+	if result.error != 0 {
+		return PageText{}, errors.New("typst: " + takeString(result))
+	}
+	text := takeString(result)
+	return PageText{
+		Page:  page,
+		Text:  text,
+		Words: len(strings.Fields(text)),
+		Chars: utf8.RuneCountInString(text) - strings.Count(text, "\n"),
+	}, nil
+}
+
+// Texts returns an iterator over the text of every page, in page order.
+// Requires [WithLayout]. Yields nothing without the layout or after Close.
+func (d *Document) Texts() iter.Seq[PageText] {
+	return func(yield func(PageText) bool) {
+		for i := range d.PageCount() {
+			text, err := d.Text(i)
+			if err != nil || !yield(text) {
+				return
+			}
+		}
+	}
+}
+
+// Rect is an axis-aligned rectangle on a page, in points from the top-left
+// corner of the page.
+type Rect struct {
+	X      float64
+	Y      float64
+	Width  float64
+	Height float64
+}
+
+// Link is a clickable area of the document.
+type Link struct {
+	Page   int       // 0-based index of the page the link is on
+	Rect   Rect      // the clickable area
+	URL    string    // destination of an external link; empty for internal links
+	Target *Position // destination of an internal link; nil for external links
+}
+
+// Links returns every link in the document, external URLs and internal
+// links (references, outline entries, ...), in page order. Requires
+// [WithLayout]. Returns nil without the layout, or after Close.
+func (d *Document) Links() []Link {
+	var links []Link
+	if !d.inspect(func(h *C.TypstDocument) C.TypstResult { return C.typst_document_links(h) }, &links) {
+		return nil
+	}
+	return links
+}
+
+// FontUsage describes a font used to set text in the document.
+type FontUsage struct {
+	Family string // font family, as in #set text(font: ...)
+	Style  string // "normal", "italic" or "oblique"
+	Weight int    // 100 (thin) to 900 (black); 400 is regular
+	Glyphs int    // number of glyphs set in this font
+}
+
+// FontsUsed returns the fonts text in the document was set in, in order of
+// first use. Fonts picked by fallback are included, so an unexpected entry
+// means a family did not cover some text. Requires [WithLayout]. Returns nil
+// without the layout, or after Close.
+func (d *Document) FontsUsed() []FontUsage {
+	var fonts []FontUsage
+	if !d.inspect(func(h *C.TypstDocument) C.TypstResult { return C.typst_document_fonts(h) }, &fonts) {
+		return nil
+	}
+	return fonts
+}
+
+// MissingGlyph is a character that no available font could render. It is
+// drawn as a placeholder box ("tofu") in the PDF.
+type MissingGlyph struct {
+	Char   rune   // the code point
+	Page   int    // 0-based index of the page it is on
+	Path   string // file containing the character; empty for the main source
+	Line   int    // 1-based line, 0 if unknown
+	Column int    // 1-based column, 0 if unknown
+}
+
+// MissingGlyphs returns every occurrence of a character that none of the
+// compiler's fonts (bundled, custom or fallback) could render, in page
+// order. Check it before sending out documents with user-supplied text in
+// scripts the fonts may not cover. Returns nil if all text rendered.
+//
+// The layout is walked on each call, so the check costs nothing unless it
+// is made. Requires [WithLayout]; without it, or after Close, MissingGlyphs
+// returns an error rather than reporting that nothing is missing.
+func (d *Document) MissingGlyphs() ([]MissingGlyph, error) {
+	if d.closed || d.handle == nil {
+		return nil, errors.New("typst: missing glyphs of closed document")
+	}
+	result := C.typst_document_missing_glyphs(d.handle)
+	if result.error != 0 {
+		return nil, errors.New("typst: " + takeString(result))
+	}
+	var missing []MissingGlyph
+	if err := json.Unmarshal(takeBytes(result), &missing); err != nil {
+		return nil, fmt.Errorf("typst: decoding missing glyphs: %w", err)
+	}
+	if len(missing) == 0 {
+		return nil, nil
+	}
+	return missing, nil
+}
@@ -0,0 +1,239 @@
+package typst
+
+import (
+	"math"
+	"testing"
+)
+
+func compileTest(t *testing.T, source string, opts ...CompileOption) *Document {
+	t.Helper()
+	c := newTestCompiler(t)
+	doc, err := c.CompileBytes([]byte(source), opts...)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	t.Cleanup(func() { doc.Close() })
+	return doc
+}
+
+func TestDocument_Pages(t *testing.T) {
+	doc := compileTest(t, `#set document(title: "Pages", author: ("Ada", "Grace"))
+#set page(paper: "a5", numbering: "i")
+One #pagebreak() Two
+#set page(paper: "a4", flipped: true, numbering: none)
+Three`)
+
+	if got := doc.PageCount(); got != 3 {
+		t.Fatalf("PageCount = %d, want 3", got)
+	}
+
+	var pages []PageInfo
+	for p := range doc.Pages() {
+		pages = append(pages, p)
+	}
+	if len(pages) != 3 {
+		t.Fatalf("Pages yielded %d pages, want 3", len(pages))
+	}
+	if p := pages[1]; p.Index != 1 || p.Number != 2 || p.Label != "ii" {
+		t.Fatalf("unexpected second page: %+v", p)
+	}
+	// A5 is 148mm × 210mm; the last page is landscape A4 without numbering.
+	if p := pages[0]; math.Abs(p.Width-419.53) > 0.1 || math.Abs(p.Height-595.28) > 0.1 {
+		t.Fatalf("unexpected A5 size: %+v", p)
+	}
+	if p := pages[2]; p.Width < p.Height || p.Label != "" {
+		t.Fatalf("unexpected last page: %+v", p)
+	}
+
+	info := doc.Info()
+	if info.Title != "Pages" || len(info.Authors) != 2 || info.PageCount != 3 {
+		t.Fatalf("unexpected info: %+v", info)
+	}
+}
+
+func TestDocument_PagesAfterClose(t *testing.T) {
+	doc := compileTest(t, "Hello")
+	doc.Close()
+
+	if doc.PageCount() != 0 {
+		t.Fatal("expected 0 pages after close")
+	}
+	for range doc.Pages() {
+		t.Fatal("expected no pages after close")
+	}
+}
+
+func TestDocument_Outline(t *testing.T) {
+	doc := compileTest(t, `= Introduction <intro>
+#lorem(10)
+== Background
+=== Details
+== Scope
+#pagebreak()
+= Results
+`)
+
+	outline := doc.Outline()
+	if len(outline) != 2 {
+		t.Fatalf("expected 2 top-level headings, got %+v", outline)
+	}
+	intro, results := outline[0], outline[1]
+	if intro.Title != "Introduction" || intro.Label != "intro" || intro.Level != 1 {
+		t.Fatalf("unexpected first heading: %+v", intro)
+	}
+	if len(intro.Children) != 2 || intro.Children[0].Title != "Background" || intro.Children[1].Title != "Scope" {
+		t.Fatalf("unexpected children: %+v", intro.Children)
+	}
+	if details := intro.Children[0].Children; len(details) != 1 || details[0].Level != 3 {
+		t.Fatalf("unexpected grandchildren: %+v", details)
+	}
+	if results.Position.Page != 1 || results.Position.Y <= 0 {
+		t.Fatalf("unexpected position of second chapter: %+v", results.Position)
+	}
+}
+
+func TestNestOutline_skippedLevel(t *testing.T) {
+	got := nestOutline([]OutlineEntry{{Level: 2, Title: "a"}, {Level: 1, Title: "b"}, {Level: 3, Title: "c"}})
+	if len(got) != 2 || got[1].Title != "b" || len(got[1].Children) != 1 || got[1].Children[0].Title != "c" {
+		t.Fatalf("unexpected tree: %+v", got)
+	}
+}
+
+func TestDocument_Text(t *testing.T) {
+	doc := compileTest(t, `#set page(width: 10cm, height: auto)
+= Report
+Hello world.
+#pagebreak()
+Second page.`, WithLayout())
+
+	first, err := doc.Text(0)
+	if err != nil {
+		t.Fatalf("Text(0) failed: %v", err)
+	}
+	if first.Text != "Report\nHello world.\n" {
+		t.Fatalf("unexpected text: %q", first.Text)
+	}
+	if first.Words != 3 || first.Chars != 18 {
+		t.Fatalf("unexpected counts: %d words, %d chars", first.Words, first.Chars)
+	}
+
+	var pages []string
+	for text := range doc.Texts() {
+		pages = append(pages, text.Text)
+	}
+	if len(pages) != 2 || pages[1] != "Second page.\n" {
+		t.Fatalf("unexpected page texts: %q", pages)
+	}
+
+	if _, err := doc.Text(2); err == nil {
+		t.Fatal("expected error for page out of range")
+	}
+}
+
+func TestDocument_withoutLayout(t *testing.T) {
+	doc := compileTest(t, "= Intro <intro>\nSee #link(\"https://typst.app\")[Typst].")
+	if _, err := doc.Text(0); err == nil {
+		t.Fatal("expected Text to fail without the layout")
+	}
+	if doc.Links() != nil || doc.Locate("intro") != nil || doc.FontsUsed() != nil {
+		t.Fatal("expected nil inspection results without the layout")
+	}
+	// Extracted at compile time, so available either way.
+	if doc.PageCount() != 1 || len(doc.Outline()) != 1 {
+		t.Fatalf("unexpected pages or outline: %d, %+v", doc.PageCount(), doc.Outline())
+	}
+}
+
+func TestDocument_Links(t *testing.T) {
+	doc := compileTest(t, `= Intro <intro>
+See #link("https://typst.app")[Typst].
+#pagebreak()
+Back to @intro.`, WithLayout())
+
+	links := doc.Links()
+	if len(links) != 2 {
+		t.Fatalf("expected 2 links, got %+v", links)
+	}
+	external, internal := links[0], links[1]
+	if external.URL != "https://typst.app" || external.Target != nil || external.Page != 0 {
+		t.Fatalf("unexpected external link: %+v", external)
+	}
+	if external.Rect.Width <= 0 || external.Rect.Height <= 0 {
+		t.Fatalf("unexpected link area: %+v", external.Rect)
+	}
+	if internal.URL != "" || internal.Target == nil || internal.Page != 1 || internal.Target.Page != 0 {
+		t.Fatalf("unexpected internal link: %+v", internal)
+	}
+}
+
+func TestDocument_Locate(t *testing.T) {
+	doc := compileTest(t, `#set page(margin: 2cm)
+Contract text.
+#pagebreak()
+#v(3cm)
+#block(width: 6cm, height: 1cm) <signature>
+#block(width: 6cm, height: 1cm) <signature>`, WithLayout())
+
+	got := doc.Locate("<signature>")
+	if len(got) != 2 {
+		t.Fatalf("expected 2 positions, got %+v", got)
+	}
+	// 2cm margin, 3cm spacing: the first block starts at least 5cm from the top.
+	if p := got[0]; p.Page != 1 || math.Abs(p.X-56.69) > 0.1 || p.Y < 141.7 {
+		t.Fatalf("unexpected first position: %+v", p)
+	}
+	if got[1].Y <= got[0].Y {
+		t.Fatalf("second signature not below the first: %+v", got)
+	}
+	if doc.Locate("missing") != nil {
+		t.Fatal("expected nil for unknown label")
+	}
+}
+
+func TestDocument_FontsUsed(t *testing.T) {
+	doc := compileTest(t, `Regular *bold* _italic_`, WithLayout())
+
+	fonts := doc.FontsUsed()
+	if len(fonts) != 3 {
+		t.Fatalf("expected 3 font variants, got %+v", fonts)
+	}
+	if f := fonts[0]; f.Family != "Libertinus Serif" || f.Style != "normal" || f.Weight != 400 || f.Glyphs == 0 {
+		t.Fatalf("unexpected regular font: %+v", f)
+	}
+	if fonts[1].Weight <= 400 || fonts[2].Style != "italic" {
+		t.Fatalf("unexpected bold/italic fonts: %+v", fonts)
+	}
+}
+
+func TestDocument_MissingGlyphs(t *testing.T) {
+	doc := compileTest(t, "Fine.\nCustomer: ༀ", WithLayout())
+	missing, err := doc.MissingGlyphs()
+	if err != nil {
+		t.Fatalf("MissingGlyphs failed: %v", err)
+	}
+	if len(missing) == 0 {
+		t.Fatal("expected missing glyphs for uncovered scripts")
+	}
+	if m := missing[0]; m.Char != 'ༀ' || m.Page != 0 || m.Line != 2 || m.Column != 11 {
+		t.Fatalf("unexpected missing glyph: %+v", m)
+	}
+
+	if got, err := compileTest(t, "All covered.", WithLayout()).MissingGlyphs(); got != nil || err != nil {
+		t.Fatalf("expected no missing glyphs, got %+v, %v", got, err)
+	}
+	if _, err := compileTest(t, "Customer: ༀ").MissingGlyphs(); err == nil {
+		t.Fatal("expected an error without the layout")
+	}
+}
+
+func TestDocument_MissingGlyphs_importedFile(t *testing.T) {
+	doc := compileTest(t, `#import "names.typ": customer
+Dear #customer,`, WithLayout(), WithFile("/names.typ", []byte("#let customer = [Tenzin ༀ]")))
+	missing, err := doc.MissingGlyphs()
+	if err != nil {
+		t.Fatalf("MissingGlyphs failed: %v", err)
+	}
+	if len(missing) == 0 || missing[0].Path != "names.typ" || missing[0].Line != 1 {
+		t.Fatalf("unexpected missing glyphs: %+v", missing)
+	}
+}
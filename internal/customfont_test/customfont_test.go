@@ -6,6 +6,8 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"strings"
 	"testing"
 
 	typst "github.com/sarat/go-typst"
@@ -75,6 +77,77 @@ func TestInit_CustomFonts(t *testing.T) {
 	t.Logf("PDF size: %d bytes (written to testdata/custom_font.pdf)", doc.Len())
 }
 
+func TestWithFontDirs(t *testing.T) {
+	c, err := typst.NewCompiler(typst.WithFontDirs(fontsDir))
+	if err != nil {
+		t.Fatalf("NewCompiler failed: %v", err)
+	}
+	defer c.Close()
+	if w := c.Warnings(); len(w) != 0 {
+		t.Fatalf("unexpected warnings: %q", w)
+	}
+
+	doc, err := c.CompileBytes(customSource, typst.WithLayout())
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	defer doc.Close()
+	if fonts := doc.FontsUsed(); len(fonts) == 0 || !strings.HasPrefix(fonts[0].Family, "Inter") {
+		t.Fatalf("custom font not used: %+v", fonts)
+	}
+}
+
+func TestWithFontFiles_mapped(t *testing.T) {
+	path := filepath.Join(fontsDir, "InterVariable.ttf")
+	c, err := typst.NewCompiler(typst.WithFontFiles(path))
+	if err != nil {
+		t.Fatalf("NewCompiler failed: %v", err)
+	}
+	defer c.Close()
+	if w := c.Warnings(); len(w) != 0 {
+		t.Fatalf("unexpected warnings: %q", w)
+	}
+
+	// Font files given by path are memory-mapped rather than read.
+	doc, err := c.CompileBytes([]byte(`#set text(font: "Inter")
+Set in a mapped font.`), typst.WithLayout())
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	defer doc.Close()
+	if fonts := doc.FontsUsed(); len(fonts) != 1 || !strings.HasPrefix(fonts[0].Family, "Inter") || fonts[0].Glyphs == 0 {
+		t.Fatalf("mapped font not used: %+v", fonts)
+	}
+
+	if !c.HasFont("Inter") {
+		t.Fatal("HasFont does not report the mapped font")
+	}
+	i := slices.IndexFunc(c.Fonts(), func(f typst.FontInfo) bool { return f.Source.Path == path })
+	if i < 0 {
+		t.Fatal("Fonts does not list the mapped font")
+	}
+	if f := c.Fonts()[i]; !strings.HasPrefix(f.Family, "Inter") || f.Source.Bundled || len(f.Axes) == 0 {
+		t.Fatalf("unexpected mapped font: %+v", f)
+	}
+}
+
+func TestCompiler_Fonts_variable(t *testing.T) {
+	c := newCompiler(t)
+	if !c.HasFont("Inter") {
+		t.Fatal("expected custom font Inter")
+	}
+	for _, f := range c.Fonts() {
+		if f.Source.Bundled {
+			continue
+		}
+		if len(f.Axes) == 0 || f.Axes[0].Min >= f.Axes[0].Max {
+			t.Fatalf("expected variation axes for %s: %+v", f.Family, f.Axes)
+		}
+		return
+	}
+	t.Fatal("custom fonts missing from catalog")
+}
+
 // --- Serial benchmarks ---
 
 func BenchmarkBundledFont_Library(b *testing.B) {
@@ -0,0 +1,184 @@
+package typst
+
+/*
+#include "typst_ffi.h"
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// PackageInfo describes a package found by [ListPackages].
+type PackageInfo struct {
+	PackageSpec        // from the directory layout
+	Dir         string // the package directory
+
+	// From the [package] table of typst.toml.
+	Entrypoint  string   // path of the file imports load, relative to Dir
+	Authors     []string // e.g. "Jane Doe <@jane>"
+	License     string   // SPDX expression, e.g. "MIT"
+	Description string
+	Compiler    string   // minimum Typst version; empty if unrestricted
+	Exclude     []string // globs of files left out when publishing
+
+	// Problems lists what keeps the package from being imported: a missing
+	// or invalid typst.toml, a manifest for another package or version than
+	// its directory or for a newer Typst, or a missing entrypoint. Empty for
+	// a usable package.
+	Problems []string
+	// Missing lists the keys Typst Universe requires that typst.toml lacks
+	// ("authors", "license", "description"). Such a package is incomplete:
+	// it can be imported, but not published.
+	Missing []string
+}
+
+// ListPackages lists the packages in a package directory laid out as
+// {dir}/{namespace}/{name}/{version}, the layout [WithPackageDir] expects.
+// Packages are ordered by namespace and name, then by version. Broken and
+// incomplete packages are listed with their Problems and Missing keys
+// rather than failing the call; only an unreadable dir is an error.
+func ListPackages(dir string) ([]PackageInfo, error) {
+	namespaces, err := readSubdirs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("typst: listing packages: %w", err)
+	}
+	var packages []PackageInfo
+	for _, namespace := range namespaces {
+		names, _ := readSubdirs(filepath.Join(dir, namespace))
+		for _, name := range names {
+			versions, _ := readSubdirs(filepath.Join(dir, namespace, name))
+			slices.SortFunc(versions, compareVersions)
+			for _, version := range versions {
+				spec := PackageSpec{Namespace: namespace, Name: name, Version: version}
+				packages = append(packages, inspectPackage(filepath.Join(dir, namespace, name, version), spec))
+			}
+		}
+	}
+	return packages, nil
+}
+
+// readSubdirs returns the names of the directories in dir, skipping hidden
+// ones such as unfinished downloads.
+func readSubdirs(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+// inspectPackage reads the manifest of the package in dir and checks it.
+func inspectPackage(dir string, spec PackageSpec) PackageInfo {
+	info := PackageInfo{PackageSpec: spec, Dir: dir}
+	data, err := os.ReadFile(filepath.Join(dir, "typst.toml"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			info.Problems = append(info.Problems, "no typst.toml")
+		} else {
+			info.Problems = append(info.Problems, fmt.Sprintf("reading typst.toml: %v", err))
+		}
+		return info
+	}
+	m, err := readManifest(data, spec)
+	if err != nil {
+		info.Problems = append(info.Problems, fmt.Sprintf("invalid typst.toml: %v", err))
+		return info
+	}
+	info.Entrypoint = m.Entrypoint
+	info.Authors = m.Authors
+	info.License = m.License
+	info.Description = m.Description
+	info.Compiler = m.Compiler
+	info.Exclude = m.Exclude
+
+	if m.Problem != "" {
+		info.Problems = append(info.Problems, m.Problem)
+	}
+	if len(m.Authors) == 0 {
+		info.Missing = append(info.Missing, "authors")
+	}
+	if m.License == "" {
+		info.Missing = append(info.Missing, "license")
+	}
+	if m.Description == "" {
+		info.Missing = append(info.Missing, "description")
+	}
+	entry := filepath.FromSlash(m.Entrypoint)
+	if _, err := os.Stat(filepath.Join(dir, entry)); !filepath.IsLocal(entry) || err != nil {
+		info.Problems = append(info.Problems, fmt.Sprintf("entrypoint %s not found", m.Entrypoint))
+	}
+	return info
+}
+
+// compareVersions orders versions numerically by component; components that
+// are not numbers compare as strings.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		x, errX := strconv.ParseUint(as[i], 10, 64)
+		y, errY := strconv.ParseUint(bs[i], 10, 64)
+		switch {
+		case errX != nil || errY != nil:
+			if c := strings.Compare(as[i], bs[i]); c != 0 {
+				return c
+			}
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return len(as) - len(bs)
+}
+
+// packageManifest is the [package] table of a typst.toml.
+type packageManifest struct {
+	Name        string   `json:"name"`
+	Version     string   `json:"version"`
+	Entrypoint  string   `json:"entrypoint"`
+	Authors     []string `json:"authors"`
+	License     string   `json:"license"`
+	Description string   `json:"description"`
+	Compiler    string   `json:"compiler"`
+	Exclude     []string `json:"exclude"`
+
+	// Problem is why importing the package as the spec given to
+	// readManifest would fail; empty if it would not.
+	Problem string `json:"problem"`
+}
+
+// readManifest parses a typst.toml with Typst's own manifest parser, so it
+// accepts exactly what importing a package accepts. The manifest is checked
+// against spec, if given, as an import of spec checks it.
+func readManifest(data []byte, spec PackageSpec) (packageManifest, error) {
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+	var cspec C.TypstSlice
+	if spec != (PackageSpec{}) {
+		cspec = cString(&pinner, spec.String())
+	}
+	result := C.typst_package_manifest(cBytes(&pinner, data), cspec)
+	if result.error != 0 {
+		return packageManifest{}, errors.New(takeString(result))
+	}
+	var m packageManifest
+	if err := json.Unmarshal(takeBytes(result), &m); err != nil {
+		return packageManifest{}, fmt.Errorf("decoding manifest: %w", err)
+	}
+	return m, nil
+}
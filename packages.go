@@ -0,0 +1,317 @@
+package typst
+
+/*
+#include "typst_ffi.h"
+*/
+import "C"
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPackageRegistry is the registry [WithPackageDownload] downloads
+// packages from.
+const DefaultPackageRegistry = "https://packages.typst.org"
+
+// maxPackageSize bounds the unpacked size of a downloaded package.
+const maxPackageSize = 256 << 20
+
+// maxDownloadRounds bounds how often a compilation is retried after
+// downloading packages; each round fetches the packages imported by those of
+// the previous one.
+const maxDownloadRounds = 8
+
+// packageClient fetches package archives.
+var packageClient = &http.Client{Timeout: 2 * time.Minute}
+
+// downloadLocks serialize downloads to the same package destination, so
+// concurrent compilations do not fetch the same package twice, while
+// different packages mostly download in parallel. A destination always maps
+// to the same lock; see downloadLock.
+var downloadLocks [64]sync.Mutex
+
+// downloadLock returns the lock for downloads to dest.
+func downloadLock(dest string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(dest))
+	return &downloadLocks[h.Sum32()%uint32(len(downloadLocks))]
+}
+
+// downloadable reports whether spec can be fetched from a registry: only
+// the @preview namespace is published there.
+func downloadable(spec PackageSpec) bool {
+	return spec.Namespace == "preview"
+}
+
+// PackageSpec identifies a package version, as in
+// #import "@preview/name:1.0.0".
+type PackageSpec struct {
+	Namespace string
+	Name      string
+	Version   string
+}
+
+// String returns the import form of the spec, e.g. "@preview/cetz:0.3.1".
+func (s PackageSpec) String() string {
+	return "@" + s.Namespace + "/" + s.Name + ":" + s.Version
+}
+
+// parsePackageSpec parses the import form of a spec.
+func parsePackageSpec(s string) (PackageSpec, bool) {
+	rest, ok := strings.CutPrefix(s, "@")
+	if !ok {
+		return PackageSpec{}, false
+	}
+	namespace, rest, ok := strings.Cut(rest, "/")
+	if !ok {
+		return PackageSpec{}, false
+	}
+	name, version, ok := strings.Cut(rest, ":")
+	if !ok || namespace == "" || name == "" || version == "" {
+		return PackageSpec{}, false
+	}
+	return PackageSpec{Namespace: namespace, Name: name, Version: version}, true
+}
+
+// WithPackageDownload downloads packages missing from the package
+// directories from [DefaultPackageRegistry], then retries the compilation.
+// Only @preview packages are downloaded. They go to the last package
+// directory ([DefaultPackageDir] by default, created if needed), or to the
+// directory of the namespace if it is mapped with [WithPackageNamespace].
+func WithPackageDownload() CompileOption {
+	return WithPackageRegistry(DefaultPackageRegistry)
+}
+
+// WithPackageRegistry is like [WithPackageDownload], downloading from the
+// registry at url instead, e.g. a local mirror. Archives are fetched from
+// {url}/{namespace}/{name}-{version}.tar.gz.
+func WithPackageRegistry(url string) CompileOption {
+	return func(cfg *compileConfig) {
+		cfg.registry = strings.TrimSuffix(url, "/")
+	}
+}
+
+// run calls the Rust side until it succeeds or fails for a reason other
+// than missing @preview packages, downloading those in between if enabled.
+// Missing packages of other namespaces are left to the [CompileError].
+func (cfg *compileConfig) run(call func() C.TypstResult) (C.TypstResult, error) {
+	for round := 0; ; round++ {
+		result := call()
+		if result.error == 0 {
+			return result, nil
+		}
+		// takeString frees the result, so it is not returned from here on.
+		ce := newCompileError(takeString(result))
+		missing := slices.DeleteFunc(slices.Clone(ce.MissingPackages), func(spec PackageSpec) bool {
+			return !downloadable(spec)
+		})
+		if cfg.registry == "" || len(missing) == 0 || round == maxDownloadRounds {
+			return C.TypstResult{}, ce
+		}
+		for _, spec := range missing {
+			if err := downloadPackage(cfg.registry, cfg.downloadDir(spec), spec); err != nil {
+				return C.TypstResult{}, err
+			}
+		}
+	}
+}
+
+// packageDirs returns where a package may be, in lookup order: in its
+// namespace directory, or in each package directory.
+func (cfg *compileConfig) packageDirs(spec PackageSpec) []string {
+	if dir, ok := cfg.namespaces[spec.Namespace]; ok {
+		return []string{filepath.Join(dir, spec.Name, spec.Version)}
+	}
+	dirs := make([]string, len(cfg.packagePaths))
+	for i, path := range cfg.packagePaths {
+		dirs[i] = filepath.Join(path, spec.Namespace, spec.Name, spec.Version)
+	}
+	return dirs
+}
+
+// findPackage returns the directory a package is loaded from, or "" if no
+// package directory has it.
+func (cfg *compileConfig) findPackage(spec PackageSpec) string {
+	for _, dir := range cfg.packageDirs(spec) {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir
+		}
+	}
+	return ""
+}
+
+// downloadDir returns the directory a package is downloaded to: in its
+// namespace directory, or in the last package directory. Empty if there is
+// no package directory.
+func (cfg *compileConfig) downloadDir(spec PackageSpec) string {
+	dirs := cfg.packageDirs(spec)
+	if len(dirs) == 0 {
+		return ""
+	}
+	return dirs[len(dirs)-1]
+}
+
+// downloadPackage fetches a package archive from registry and unpacks it
+// into dest. The archive is unpacked into a temporary directory and renamed
+// into place once its manifest matches spec, so an interrupted download
+// leaves no partial package behind.
+func downloadPackage(registry, dest string, spec PackageSpec) error {
+	if !downloadable(spec) {
+		return fmt.Errorf("typst: package %s: only @preview packages can be downloaded", spec)
+	}
+	if dest == "" {
+		return fmt.Errorf("typst: package %s: no package directory", spec)
+	}
+
+	lock := downloadLock(dest)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if _, err := os.Stat(dest); err == nil {
+		return nil // downloaded by a concurrent compilation
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return fmt.Errorf("typst: package %s: %w", spec, err)
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(dest), ".download-")
+	if err != nil {
+		return fmt.Errorf("typst: package %s: %w", spec, err)
+	}
+	defer os.RemoveAll(tmp)
+
+	url := fmt.Sprintf("%s/%s/%s-%s.tar.gz", registry, spec.Namespace, spec.Name, spec.Version)
+	if err := fetchPackage(url, tmp); err != nil {
+		return fmt.Errorf("typst: package %s: %w", spec, err)
+	}
+	if err := verifyPackage(tmp, spec); err != nil {
+		return fmt.Errorf("typst: package %s: %w", spec, err)
+	}
+	if err := renamePackage(tmp, dest); err != nil {
+		if _, serr := os.Stat(dest); serr == nil {
+			return nil // downloaded by another process meanwhile
+		}
+		return fmt.Errorf("typst: package %s: %w", spec, err)
+	}
+	return nil
+}
+
+// renamePackage moves a package prepared in the temporary directory tmp to
+// dest. MkdirTemp creates tmp private to the user, so it is first given the
+// permissions of a regular package directory.
+func renamePackage(tmp, dest string) error {
+	if err := os.Chmod(tmp, 0o755); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
+
+// fetchPackage downloads the gzipped tarball at url and unpacks it into dir.
+func fetchPackage(url, dir string) error {
+	resp, err := packageClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return errors.New("not found in the registry")
+	default:
+		return fmt.Errorf("downloading %s: %s", url, resp.Status)
+	}
+
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return fmt.Errorf("invalid archive: %w", err)
+	}
+	return untar(tar.NewReader(gz), dir)
+}
+
+// untar writes the regular files and directories of an archive below dir.
+// Entries escaping dir are rejected; links and special files are skipped.
+func untar(tr *tar.Reader, dir string) error {
+	var size int64
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid archive: %w", err)
+		}
+		name := filepath.FromSlash(strings.TrimPrefix(hdr.Name, "./"))
+		if name == "" || name == "." {
+			continue
+		}
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("invalid archive: unsafe path %q", hdr.Name)
+		}
+		path := filepath.Join(dir, name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			size += hdr.Size
+			if size > maxPackageSize {
+				return errors.New("invalid archive: package too large")
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				return err
+			}
+			if err := writeFile(path, io.LimitReader(tr, hdr.Size)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// writeFile creates the file at path with the contents of r.
+func writeFile(path string, r io.Reader) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// verifyPackage checks that the unpacked package in dir has a manifest for
+// spec and contains its entrypoint.
+func verifyPackage(dir string, spec PackageSpec) error {
+	data, err := os.ReadFile(filepath.Join(dir, "typst.toml"))
+	if err != nil {
+		return errors.New("invalid archive: no typst.toml")
+	}
+	m, err := readManifest(data, spec)
+	if err != nil {
+		return fmt.Errorf("invalid typst.toml: %w", err)
+	}
+	if m.Problem != "" {
+		return errors.New(m.Problem)
+	}
+	entry := filepath.FromSlash(m.Entrypoint)
+	if !filepath.IsLocal(entry) {
+		return fmt.Errorf("invalid entrypoint %q", m.Entrypoint)
+	}
+	if _, err := os.Stat(filepath.Join(dir, entry)); err != nil {
+		return fmt.Errorf("entrypoint %s missing", m.Entrypoint)
+	}
+	return nil
+}
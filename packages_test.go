@@ -0,0 +1,296 @@
+package typst
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// packageArchive builds a gzipped tarball of the given files.
+func packageArchive(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+// packageMirror serves archives by URL path and counts requests.
+func packageMirror(t *testing.T, archives map[string][]byte) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		data, ok := archives[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+func TestWithPackageRegistry(t *testing.T) {
+	c := newTestCompiler(t)
+	manifest, _ := os.ReadFile("testdata/packages/preview/example/0.1.0/typst.toml")
+	lib, _ := os.ReadFile("testdata/packages/preview/example/0.1.0/lib.typ")
+	srv, hits := packageMirror(t, map[string][]byte{
+		"/preview/example-0.1.0.tar.gz": packageArchive(t, map[string]string{
+			"typst.toml": string(manifest),
+			"lib.typ":    string(lib),
+		}),
+	})
+	pkgDir := t.TempDir()
+	source, _ := os.ReadFile("testdata/with_package.typ")
+
+	_, err := c.CompileBytes(source, WithPackageDir(pkgDir))
+	ce, ok := err.(*CompileError)
+	if !ok || len(ce.MissingPackages) != 1 || ce.MissingPackages[0].String() != "@preview/example:0.1.0" {
+		t.Fatalf("expected missing package, got %v", err)
+	}
+
+	for range 2 {
+		doc, err := c.CompileBytes(source, WithPackageDir(pkgDir), WithPackageRegistry(srv.URL))
+		if err != nil {
+			t.Fatalf("CompileBytes with package download failed: %v", err)
+		}
+		doc.Close()
+	}
+	if n := hits.Load(); n != 1 {
+		t.Fatalf("expected 1 download, got %d", n)
+	}
+	if _, err := os.Stat(filepath.Join(pkgDir, "preview", "example", "0.1.0", "lib.typ")); err != nil {
+		t.Fatalf("package not unpacked: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(pkgDir, "preview", "example", "0.1.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0o755 {
+		t.Fatalf("package directory has mode %v, want 0755", info.Mode().Perm())
+	}
+}
+
+func TestWithPackageRegistry_concurrent(t *testing.T) {
+	c := newTestCompiler(t)
+	manifest, _ := os.ReadFile("testdata/packages/preview/example/0.1.0/typst.toml")
+	lib, _ := os.ReadFile("testdata/packages/preview/example/0.1.0/lib.typ")
+	srv, hits := packageMirror(t, map[string][]byte{
+		"/preview/example-0.1.0.tar.gz": packageArchive(t, map[string]string{
+			"typst.toml": string(manifest),
+			"lib.typ":    string(lib),
+		}),
+	})
+	pkgDir := t.TempDir()
+	source, _ := os.ReadFile("testdata/with_package.typ")
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			doc, err := c.CompileBytes(source, WithPackageDir(pkgDir), WithPackageRegistry(srv.URL))
+			if err == nil {
+				doc.Close()
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("concurrent compile failed: %v", err)
+		}
+	}
+	if n := hits.Load(); n != 1 {
+		t.Fatalf("expected 1 download, got %d", n)
+	}
+}
+
+func TestWithPackageRegistry_invalid(t *testing.T) {
+	c := newTestCompiler(t)
+	srv, _ := packageMirror(t, map[string][]byte{
+		"/preview/example-0.1.0.tar.gz": packageArchive(t, map[string]string{
+			"typst.toml": "[package]\nname = \"example\"\nversion = \"0.2.0\"\nentrypoint = \"lib.typ\"\n",
+			"lib.typ":    "#let example-func() = []",
+		}),
+		"/preview/evil-0.1.0.tar.gz": packageArchive(t, map[string]string{
+			"../escape.typ": "",
+		}),
+	})
+	pkgDir := t.TempDir()
+
+	for _, source := range []string{
+		`#import "@preview/example:0.1.0": example-func`,
+		`#import "@preview/evil:0.1.0"`,
+		`#import "@preview/absent:0.1.0"`,
+		`#import "@local/example:0.1.0"`,
+	} {
+		if _, err := c.CompileBytes([]byte(source), WithPackageDir(pkgDir), WithPackageRegistry(srv.URL)); err == nil {
+			t.Fatalf("expected error for %s", source)
+		}
+	}
+	// Only @preview packages are downloaded; others fail as without a registry.
+	_, err := c.CompileBytes([]byte(`#import "@local/example:0.1.0"`), WithPackageDir(pkgDir), WithPackageRegistry(srv.URL))
+	var ce *CompileError
+	if !errors.As(err, &ce) || len(ce.MissingPackages) != 1 || ce.MissingPackages[0].Namespace != "local" {
+		t.Fatalf("expected CompileError with the missing @local package, got %v", err)
+	}
+
+	entries, _ := os.ReadDir(filepath.Join(pkgDir, "preview", "example"))
+	if len(entries) != 0 {
+		t.Fatalf("leftover files after failed download: %v", entries)
+	}
+	if _, err := os.Stat(filepath.Join(pkgDir, "preview", "escape.typ")); err == nil {
+		t.Fatal("archive escaped the package directory")
+	}
+}
+
+func TestReadManifest(t *testing.T) {
+	data := []byte(`# comment
+[package]
+name = "cetz" # trailing comment
+version = '0.3.1'
+entrypoint = "src/lib.typ"
+authors = [
+  "Jane Doe <@jane>", # first
+  "John \"JD\" Doe",
+]
+license = "MIT OR Apache-2.0"
+description = "Drawing # with Typst"
+compiler = "0.13.0"
+exclude = ["tests/*", 'docs/']
+
+[tool.other]
+name = "ignored"
+`)
+	spec := PackageSpec{Namespace: "preview", Name: "cetz", Version: "0.3.1"}
+	m, err := readManifest(data, spec)
+	if err != nil {
+		t.Fatalf("readManifest failed: %v", err)
+	}
+	if m.Name != "cetz" || m.Version != "0.3.1" || m.Entrypoint != "src/lib.typ" ||
+		m.License != "MIT OR Apache-2.0" || m.Description != "Drawing # with Typst" || m.Compiler != "0.13.0" {
+		t.Fatalf("unexpected manifest: %+v", m)
+	}
+	if !slices.Equal(m.Authors, []string{"Jane Doe <@jane>", `John "JD" Doe`}) || !slices.Equal(m.Exclude, []string{"tests/*", "docs/"}) {
+		t.Fatalf("unexpected arrays: %q %q", m.Authors, m.Exclude)
+	}
+	if m.Problem != "" {
+		t.Fatalf("unexpected problem: %s", m.Problem)
+	}
+
+	spec.Name = "other"
+	if m, err := readManifest(data, spec); err != nil || !strings.Contains(m.Problem, "mismatched name") {
+		t.Fatalf("expected a mismatched name, got %+v, %v", m, err)
+	}
+	if _, err := readManifest([]byte("[package]\nname = \"x\"\nversion = \"1.0.0\"\n"), PackageSpec{}); err == nil {
+		t.Fatal("expected error for a manifest without entrypoint")
+	}
+}
+
+func TestListPackages(t *testing.T) {
+	packages, err := ListPackages("testdata/packages")
+	if err != nil {
+		t.Fatalf("ListPackages failed: %v", err)
+	}
+	if len(packages) != 1 {
+		t.Fatalf("expected 1 package, got %+v", packages)
+	}
+	p := packages[0]
+	if p.String() != "@preview/example:0.1.0" || p.Entrypoint != "lib.typ" {
+		t.Fatalf("unexpected package: %+v", p)
+	}
+	// The test package imports fine but lacks the keys Typst Universe
+	// requires.
+	if len(p.Problems) != 0 {
+		t.Fatalf("unexpected problems: %q", p.Problems)
+	}
+	if !slices.Equal(p.Missing, []string{"authors", "license", "description"}) {
+		t.Fatalf("unexpected missing keys: %q", p.Missing)
+	}
+
+	dir := t.TempDir()
+	for _, v := range []string{"0.10.0", "0.9.0"} {
+		os.MkdirAll(filepath.Join(dir, "local", "broken", v), 0o755)
+	}
+	os.WriteFile(filepath.Join(dir, "local", "broken", "0.10.0", "typst.toml"),
+		[]byte("[package]\nname = \"other\"\nversion = \"0.10.0\"\nentrypoint = \"lib.typ\"\n"), 0o644)
+	packages, err = ListPackages(dir)
+	if err != nil {
+		t.Fatalf("ListPackages failed: %v", err)
+	}
+	if len(packages) != 2 || packages[0].Version != "0.9.0" || packages[1].Version != "0.10.0" {
+		t.Fatalf("unexpected packages: %+v", packages)
+	}
+	if !slices.Equal(packages[0].Problems, []string{"no typst.toml"}) {
+		t.Fatalf("unexpected problems: %q", packages[0].Problems)
+	}
+	if len(packages[1].Problems) != 2 || !strings.Contains(packages[1].Problems[0], "mismatched name") ||
+		packages[1].Problems[1] != "entrypoint lib.typ not found" {
+		t.Fatalf("unexpected problems: %q", packages[1].Problems)
+	}
+
+	if _, err := ListPackages(filepath.Join(dir, "missing")); err == nil {
+		t.Fatal("expected error for a missing directory")
+	}
+}
+
+func TestWithPackagePaths(t *testing.T) {
+	c := newTestCompiler(t)
+	writePackage := func(dir, name, body string) {
+		os.MkdirAll(dir, 0o755)
+		os.WriteFile(filepath.Join(dir, "typst.toml"), []byte("[package]\nname = \""+name+"\"\nversion = \"1.0.0\"\nentrypoint = \"lib.typ\"\n"), 0o644)
+		os.WriteFile(filepath.Join(dir, "lib.typ"), []byte(`#let msg = "`+body+`"`), 0o644)
+	}
+	project, shared, acme := t.TempDir(), t.TempDir(), t.TempDir()
+	writePackage(filepath.Join(project, "local", "util", "1.0.0"), "util", "project")
+	writePackage(filepath.Join(shared, "local", "util", "1.0.0"), "util", "shared")
+	writePackage(filepath.Join(shared, "local", "extra", "1.0.0"), "extra", "extra")
+	writePackage(filepath.Join(acme, "brand", "1.0.0"), "brand", "acme")
+	// Namespace directories take precedence over the search paths.
+	writePackage(filepath.Join(shared, "acme", "brand", "1.0.0"), "brand", "ignored")
+
+	opts := []CompileOption{WithPackagePaths(project, shared), WithPackageNamespace("@acme", acme)}
+	got, err := c.Eval(`{
+		import "@local/util:1.0.0": msg as a
+		import "@local/extra:1.0.0": msg as b
+		import "@acme/brand:1.0.0": msg as c
+		(a, b, c)
+	}`, opts...)
+	if err != nil {
+		t.Fatalf("Eval failed: %v", err)
+	}
+	if want := []any{"project", "extra", "acme"}; !slices.Equal(got.([]any), want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	_, err = c.Eval(`{ import "@local/extra:1.0.0": msg; msg }`, WithPackagePaths(project))
+	if ce, ok := err.(*CompileError); !ok || len(ce.MissingPackages) != 1 {
+		t.Fatalf("expected missing package, got %v", err)
+	}
+}
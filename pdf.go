@@ -0,0 +1,164 @@
+package typst
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Relationship describes how an attached file relates to the PDF, as
+// recorded in the AFRelationship entry required by PDF/A-3 and Factur-X.
+type Relationship string
+
+const (
+	RelationshipUnset       Relationship = ""            // no relationship recorded
+	RelationshipSource      Relationship = "source"      // the original source of the document
+	RelationshipData        Relationship = "data"        // data used to derive the document, e.g. an XML invoice
+	RelationshipAlternative Relationship = "alternative" // an alternative representation of the document
+	RelationshipSupplement  Relationship = "supplement"  // supplemental information
+	RelationshipUnspecified Relationship = "unspecified" // explicitly unspecified
+)
+
+// attachmentsPath is the in-memory file listing the attachments of a
+// compilation, outside the user's namespace.
+const attachmentsPath = "/.go-typst/attachments.json"
+
+// attachPreamble embeds the attachments listed in attachmentsPath. It is
+// constant: names and descriptions reach Typst as decoded JSON strings and
+// the data as in-memory files, never as markup.
+const attachPreamble = `#for a in json("` + attachmentsPath + `") { pdf.attach(a.name, read(a.file, encoding: none), ..a.args) }`
+
+// attachment is a file to embed in the PDF, in the form attachPreamble
+// reads.
+type attachment struct {
+	Name string            `json:"name"`
+	File string            `json:"file"` // in-memory file holding the data
+	Args map[string]string `json:"args"` // optional pdf.attach arguments
+}
+
+// WithAttachment embeds data as a file attachment in the exported PDF,
+// under the file name name. mime and description are optional. For
+// e-invoices (Factur-X, ZUGFeRD) combine it with a PDF/A-3 standard:
+//
+//	typst.WithAttachment("factur-x.xml", "text/xml", "Factur-X invoice", xml, typst.RelationshipAlternative),
+//	typst.WithPDFStandards(typst.PDFA3B),
+//
+// The data slice is not retained after the compile call.
+func WithAttachment(name, mime, description string, data []byte, relationship Relationship) CompileOption {
+	return func(cfg *compileConfig) {
+		file := fmt.Sprintf("/.go-typst/attachments/%d", len(cfg.attachments))
+		cfg.files = append(cfg.files, virtualFile{path: file, data: data})
+
+		args := make(map[string]string)
+		for key, value := range map[string]string{
+			"relationship": string(relationship),
+			"mime-type":    mime,
+			"description":  description,
+		} {
+			if value != "" {
+				args[key] = value
+			}
+		}
+		cfg.attachments = append(cfg.attachments, attachment{Name: name, File: file, Args: args})
+	}
+}
+
+// attach lists the attachments for attachPreamble, if there are any.
+func (cfg *compileConfig) attach() {
+	if len(cfg.attachments) == 0 {
+		return
+	}
+	cfg.files = append(cfg.files, virtualFile{path: attachmentsPath, data: encodeData(cfg.attachments)})
+	cfg.preamble = append(cfg.preamble, attachPreamble)
+}
+
+// PDFStandard is a PDF version or conformance standard the export must
+// comply with, named as in the typst CLI's --pdf-standard flag.
+type PDFStandard string
+
+const (
+	PDF14  PDFStandard = "1.4"
+	PDF15  PDFStandard = "1.5"
+	PDF16  PDFStandard = "1.6"
+	PDF17  PDFStandard = "1.7"
+	PDF20  PDFStandard = "2.0"
+	PDFA1B PDFStandard = "a-1b"
+	PDFA1A PDFStandard = "a-1a"
+	PDFA2B PDFStandard = "a-2b"
+	PDFA2U PDFStandard = "a-2u"
+	PDFA2A PDFStandard = "a-2a"
+	PDFA3B PDFStandard = "a-3b"
+	PDFA3U PDFStandard = "a-3u"
+	PDFA3A PDFStandard = "a-3a"
+	PDFA4  PDFStandard = "a-4"
+	PDFA4F PDFStandard = "a-4f"
+	PDFA4E PDFStandard = "a-4e"
+	PDFUA1 PDFStandard = "ua-1"
+)
+
+// WithPDFStandards makes the PDF export comply with the given standards.
+// Content that violates them, such as missing alt text under PDF/UA,
+// fails the export with a [CompileError].
+func WithPDFStandards(standards ...PDFStandard) CompileOption {
+	return func(cfg *compileConfig) {
+		cfg.pdfStandards = append(cfg.pdfStandards, standards...)
+	}
+}
+
+// Metadata is PDF document information. Typst takes it from the document's
+// #set document(...) rule; [WithMetadata] overrides it per compile.
+//
+// The PDF creator, producer and document language are not supported: the
+// Typst PDF exporter always records itself as creator and producer, and the
+// predominant text language as the document language, with no option to
+// override them. Use [WithLang] to change the text language.
+type Metadata struct {
+	Title    string
+	Authors  []string
+	Subject  string // Typst's document description
+	Keywords []string
+	Date     time.Time // creation date; only the calendar date is used
+}
+
+// WithMetadata overrides the document metadata with the non-empty fields
+// of m; other fields keep the values set by the document.
+func WithMetadata(m Metadata) CompileOption {
+	return func(cfg *compileConfig) {
+		cfg.metadata = &m
+	}
+}
+
+// metadataWire is the JSON form of [Metadata] exchanged with the Rust side.
+type metadataWire struct {
+	Title    string   `json:"title,omitempty"`
+	Authors  []string `json:"authors,omitempty"`
+	Subject  string   `json:"subject,omitempty"`
+	Keywords []string `json:"keywords,omitempty"`
+	Date     string   `json:"date,omitempty"` // YYYY-MM-DD, optionally followed by THH:MM:SS
+}
+
+// overrides encodes the document info fields of m for the Rust side.
+func (m *Metadata) overrides() ([]byte, error) {
+	w := metadataWire{
+		Title:    m.Title,
+		Authors:  m.Authors,
+		Subject:  m.Subject,
+		Keywords: m.Keywords,
+	}
+	if !m.Date.IsZero() {
+		w.Date = m.Date.Format(time.DateOnly)
+	}
+	return json.Marshal(w)
+}
+
+// metadata converts the wire form back to [Metadata].
+func (w *metadataWire) metadata() Metadata {
+	m := Metadata{
+		Title:    w.Title,
+		Authors:  w.Authors,
+		Subject:  w.Subject,
+		Keywords: w.Keywords,
+		Date:     parseDatetime(w.Date),
+	}
+	return m
+}
@@ -0,0 +1,83 @@
+package typst
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+const invoiceXML = `<?xml version="1.0" encoding="UTF-8"?>
+<rsm:CrossIndustryInvoice xmlns:rsm="urn:un:unece:uncefact:data:standard:CrossIndustryInvoice:100"/>`
+
+func TestWithAttachment(t *testing.T) {
+	c := newTestCompiler(t)
+	source := []byte("#set document(title: \"Invoice\")\n= Invoice\n")
+
+	plain, err := c.CompileBytes(source)
+	if err != nil {
+		t.Fatalf("compile without attachment failed: %v", err)
+	}
+	defer plain.Close()
+
+	doc, err := c.CompileBytes(source,
+		WithAttachment("factur-x.xml", "text/xml", "Factur-X invoice", []byte(invoiceXML), RelationshipAlternative),
+		WithPDFStandards(PDFA3B),
+	)
+	if err != nil {
+		t.Fatalf("compile with attachment failed: %v", err)
+	}
+	defer doc.Close()
+
+	if !bytes.HasPrefix(doc.Bytes(), []byte("%PDF-")) {
+		t.Fatal("output does not look like a PDF")
+	}
+	if doc.Len() <= plain.Len() {
+		t.Fatalf("PDF with attachment (%d bytes) is not larger than without (%d bytes)", doc.Len(), plain.Len())
+	}
+	for _, want := range []string{"(factur-x.xml)", "/AFRelationship /Alternative"} {
+		if !bytes.Contains(doc.Bytes(), []byte(want)) {
+			t.Fatalf("PDF lacks %s", want)
+		}
+	}
+}
+
+func TestWithPDFStandards_unknown(t *testing.T) {
+	c := newTestCompiler(t)
+	_, err := c.CompileBytes([]byte("Hello"), WithPDFStandards("a-9z"))
+	var ce *CompileError
+	if !errors.As(err, &ce) {
+		t.Fatalf("expected CompileError, got %T: %v", err, err)
+	}
+}
+
+func TestWithMetadata(t *testing.T) {
+	c := newTestCompiler(t)
+	source := []byte("#set document(title: \"Draft\", author: \"Template\", keywords: (\"a\",))\nHallo")
+	date := time.Date(2024, 5, 17, 0, 0, 0, 0, time.UTC)
+	doc, err := c.CompileBytes(source, WithMetadata(Metadata{
+		Title:   "Quarterly Report",
+		Subject: "Q2 numbers",
+		Date:    date,
+	}))
+	if err != nil {
+		t.Fatalf("compile with metadata failed: %v", err)
+	}
+	defer doc.Close()
+
+	got := doc.Metadata()
+	if got.Title != "Quarterly Report" || got.Subject != "Q2 numbers" {
+		t.Fatalf("overrides not applied: %+v", got)
+	}
+	if len(got.Authors) != 1 || got.Authors[0] != "Template" || len(got.Keywords) != 1 {
+		t.Fatalf("document values not kept: %+v", got)
+	}
+	if !got.Date.Equal(date) {
+		t.Fatalf("date = %v, want %v", got.Date, date)
+	}
+
+	doc.Close()
+	if got := doc.Metadata(); got.Title != "" {
+		t.Fatalf("expected zero metadata after close, got %+v", got)
+	}
+}
@@ -0,0 +1,47 @@
+package typst
+
+import "strings"
+
+// WithPreamble prepends Typst markup to the source for a single compile,
+// typically set rules such as a paper size or language. Multiple preambles
+// are applied in order. The source is not modified, and diagnostics keep
+// pointing at its original lines; errors in the preamble itself are
+// reported with the path "<preamble>".
+func WithPreamble(markup string) CompileOption {
+	return func(cfg *compileConfig) {
+		cfg.preamble = append(cfg.preamble, markup)
+	}
+}
+
+// WithPaper sets the page size to a Typst paper name, such as "a4" or
+// "us-letter".
+func WithPaper(paper string) CompileOption {
+	return WithPreamble("#set page(paper: " + typstString(paper) + ")")
+}
+
+// WithLang sets the text language as an ISO 639-1/2/3 code, such as "de".
+func WithLang(lang string) CompileOption {
+	return WithPreamble("#set text(lang: " + typstString(lang) + ")")
+}
+
+// WithWatermark draws text diagonally across the background of every
+// page, e.g. "DRAFT".
+func WithWatermark(text string) CompileOption {
+	return WithPreamble("#set page(background: rotate(-45deg, text(size: 72pt, fill: luma(85%), " +
+		typstString(text) + ")))")
+}
+
+// typstStringEscaper escapes the characters that cannot appear verbatim in
+// a Typst string literal.
+var typstStringEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`"`, `\"`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\t", `\t`,
+)
+
+// typstString quotes s as a Typst string literal.
+func typstString(s string) string {
+	return `"` + typstStringEscaper.Replace(s) + `"`
+}
@@ -0,0 +1,65 @@
+package typst
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestWithPreamble(t *testing.T) {
+	c := newTestCompiler(t)
+	doc, err := c.CompileBytes([]byte(`#context assert.eq(text.lang, "de")
+Hallo!`),
+		WithPaper("a5"),
+		WithLang("de"),
+		WithWatermark(`"DRAFT"`),
+	)
+	if err != nil {
+		t.Fatalf("compile with preamble failed: %v", err)
+	}
+	defer doc.Close()
+
+	if !bytes.HasPrefix(doc.Bytes(), []byte("%PDF-")) {
+		t.Fatal("output does not look like a PDF")
+	}
+}
+
+func TestWithPreamble_diagnosticPositions(t *testing.T) {
+	c := newTestCompiler(t)
+	source := []byte("= Title\n\n#undefined-function()\n")
+	_, err := c.CompileBytes(source,
+		WithPreamble("#set page(paper: \"a4\")\n#set text(size: 10pt)"),
+		WithLang("en"),
+	)
+	var ce *CompileError
+	if !errors.As(err, &ce) {
+		t.Fatalf("expected CompileError, got %T: %v", err, err)
+	}
+	if len(ce.Diagnostics) == 0 {
+		t.Fatal("expected diagnostics")
+	}
+	d := ce.Diagnostics[0]
+	if d.Severity != "error" || d.Path != "" || d.Line != 3 || d.Column != 2 {
+		t.Fatalf("unexpected diagnostic: %+v", d)
+	}
+}
+
+func TestWithPreamble_errorInPreamble(t *testing.T) {
+	c := newTestCompiler(t)
+	_, err := c.CompileBytes([]byte("Hello"), WithPreamble("#set page(paper: 42)"))
+	var ce *CompileError
+	if !errors.As(err, &ce) || len(ce.Diagnostics) == 0 {
+		t.Fatalf("expected CompileError with diagnostics, got %v", err)
+	}
+	if got := ce.Diagnostics[0].Path; got != "<preamble>" {
+		t.Fatalf("diagnostic path = %q, want <preamble>", got)
+	}
+}
+
+func TestTypstString(t *testing.T) {
+	got := typstString("a \"quoted\" \\ line\nbreak")
+	want := `"a \"quoted\" \\ line\nbreak"`
+	if got != want {
+		t.Fatalf("typstString = %s, want %s", got, want)
+	}
+}
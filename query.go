@@ -0,0 +1,56 @@
+package typst
+
+/*
+#include "typst_ffi.h"
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+)
+
+// WithQuery runs a query against the laid-out document as part of the
+// compilation and stores the matching elements in out, as [Compiler.Query]
+// returns them. The PDF is produced as usual, so a template can hand back
+// computed values (totals, figure lists, ...) from the same compile.
+func WithQuery(selector string, out *[]json.RawMessage) CompileOption {
+	return func(c *compileConfig) {
+		c.query = selector
+		c.queryOut = out
+	}
+}
+
+// Query compiles source and returns the elements matching selector, each as
+// the JSON `typst query` prints for it. No PDF is produced.
+//
+// The selector uses the CLI's syntax: a label ("<total>"), an element
+// function ("heading"), or any selector expression
+// ("heading.where(level: 1)", "figure.where(kind: table)"). A typical use
+// reads values exposed with the metadata element:
+//
+//	#metadata(1250.00) <total>
+//
+//	results, err := c.Query(source, "<total>")
+//	// results[0]: {"func":"metadata","value":1250.0,"label":"<total>"}
+func (c *Compiler) Query(source, selector string, opts ...CompileOption) ([]json.RawMessage, error) {
+	if selector == "" {
+		return nil, errors.New("typst: empty query selector")
+	}
+	var results []json.RawMessage
+	allOpts := append(slices.Clip(opts), WithQuery(selector, &results), func(c *compileConfig) {
+		c.skipPDF = true
+	})
+	doc, err := c.compile([]byte(source), allOpts)
+	if err != nil {
+		return nil, err
+	}
+	doc.Close()
+	return results, nil
+}
+
+// queryResults decodes the results of the compile-time query into out.
+func (d *Document) queryResults(out *[]json.RawMessage) bool {
+	return d.inspect(func(h *C.TypstDocument) C.TypstResult { return C.typst_document_query(h) }, out)
+}
@@ -0,0 +1,60 @@
+package typst
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+const querySource = `= Invoice
+#let total = 40 + 2
+#metadata(total) <total>
+== Items
+== Notes`
+
+func TestCompiler_Query(t *testing.T) {
+	c := newTestCompiler(t)
+	results, err := c.Query(querySource, "<total>")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("expected 1 result, got %d", len(results))
+	}
+	var elem struct {
+		Func  string `json:"func"`
+		Value int    `json:"value"`
+	}
+	if err := json.Unmarshal(results[0], &elem); err != nil {
+		t.Fatalf("decoding result: %v", err)
+	}
+	if elem.Func != "metadata" || elem.Value != 42 {
+		t.Fatalf("unexpected result: %s", results[0])
+	}
+
+	headings, err := c.Query(querySource, "heading.where(level: 2)")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(headings) != 2 {
+		t.Fatalf("expected 2 level-2 headings, got %d", len(headings))
+	}
+}
+
+func TestCompiler_Query_invalidSelector(t *testing.T) {
+	c := newTestCompiler(t)
+	if _, err := c.Query(querySource, "1 + 2"); err == nil {
+		t.Fatal("expected error for non-selector value")
+	}
+}
+
+func TestWithQuery(t *testing.T) {
+	var results []json.RawMessage
+	doc := compileTest(t, querySource, WithQuery("<total>", &results))
+	if !bytes.HasPrefix(doc.Bytes(), []byte("%PDF-")) {
+		t.Fatal("output does not look like a PDF")
+	}
+	if len(results) != 1 {
+		t.Fatalf("expected 1 result, got %d", len(results))
+	}
+}
@@ -0,0 +1,249 @@
+package typst
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// templateDataPath is the in-memory file through which [Template.Render]
+// hands the data value to the template.
+const templateDataPath = "/data.json"
+
+// Template is a Typst template bound to a Go data type T.
+//
+// On each [Template.Render], the data value is encoded as JSON and made
+// available to the template as the in-memory file "/data.json". Templates
+// read it with:
+//
+//	#let data = json("/data.json")
+//	= Invoice #data.number
+//
+// The data is not passed through sys.inputs: Typst fixes sys.inputs when it
+// builds its standard library, which a [Compiler] does once and shares
+// across compilations (as it does for [WithGlobal]), so inputs could not
+// change per Render; and by convention they hold strings only, not nested
+// values.
+//
+// A Template is safe for concurrent use if its Compiler is.
+type Template[T any] struct {
+	compiler *Compiler
+	source   []byte
+	opts     []CompileOption
+}
+
+// NewTemplate registers source as a template for data of type T.
+//
+// When T is a struct, the template is compiled once with a sample value of
+// T, so a field the template reads that T lacks (after a rename, say) fails
+// here rather than rendering a half-empty document. The returned error
+// wraps the [*CompileError], whose diagnostics point at the field access.
+// In the sample, strings are empty, numbers zero, each slice holds one
+// element and maps are empty; the template must compile with it. A template
+// that is valid for real data can fail with the sample, e.g. one dividing by
+// a number field or parsing a date from a string field; pass
+// [WithoutTemplateCheck] to register such a template unchecked.
+//
+// opts are applied to every Render call, before any per-call options.
+func NewTemplate[T any](c *Compiler, source []byte, opts ...CompileOption) (*Template[T], error) {
+	if len(source) == 0 {
+		return nil, &CompileError{Message: "empty source"}
+	}
+	tmpl := &Template[T]{
+		compiler: c,
+		source:   slices.Clone(source),
+		opts:     slices.Clone(opts),
+	}
+
+	t := reflect.TypeFor[T]()
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Struct && !newCompileConfig(opts).noTemplateCheck {
+		sample, err := json.Marshal(sampleValue(t, make(map[reflect.Type]bool)))
+		if err != nil {
+			return nil, fmt.Errorf("encoding template data: %w", err)
+		}
+		doc, err := c.compile(tmpl.source, tmpl.compileOptions(sample, []CompileOption{func(cfg *compileConfig) {
+			cfg.skipPDF = true
+		}}))
+		if err != nil {
+			return nil, fmt.Errorf("typst: template does not compile with %s data: %w", t, err)
+		}
+		doc.Close()
+	}
+	return tmpl, nil
+}
+
+// WithoutTemplateCheck makes [NewTemplate] register the template without
+// compiling it with a sample value, for templates the sample cannot
+// satisfy. Field names are then only checked when rendering. Other
+// compilations ignore it.
+func WithoutTemplateCheck() CompileOption {
+	return func(cfg *compileConfig) {
+		cfg.noTemplateCheck = true
+	}
+}
+
+// Render compiles the template with data. The context is checked before
+// compilation starts; a running compilation cannot be interrupted.
+func (t *Template[T]) Render(ctx context.Context, data T, opts ...CompileOption) (*Document, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("encoding template data: %w", err)
+	}
+
+	return t.compiler.compile(t.source, t.compileOptions(encoded, opts))
+}
+
+// compileOptions returns the options of a compilation with the encoded data.
+func (t *Template[T]) compileOptions(data []byte, opts []CompileOption) []CompileOption {
+	allOpts := make([]CompileOption, 0, len(t.opts)+len(opts)+1)
+	allOpts = append(allOpts, t.opts...)
+	allOpts = append(allOpts, WithFile(templateDataPath, data))
+	return append(allOpts, opts...)
+}
+
+// sampleValue returns a value of type t that encodes to JSON with every
+// field present. visiting holds the struct types being sampled, so recursive
+// types end in null.
+func sampleValue(t reflect.Type, visiting map[reflect.Type]bool) any {
+	if t.Implements(marshalerType) || t.Implements(textMarshalerType) {
+		if t.Kind() == reflect.Pointer || t.Kind() == reflect.Interface {
+			return nil
+		}
+		return reflect.Zero(t).Interface()
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return sampleValue(t.Elem(), visiting)
+	case reflect.Struct:
+		if visiting[t] {
+			return nil
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+		fields := make(map[string]any)
+		for name, ft := range jsonFields(t) {
+			fields[name] = sampleValue(ft, visiting)
+		}
+		return fields
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "" // encoded as a base64 string
+		}
+		if elem := sampleValue(t.Elem(), visiting); elem != nil {
+			return []any{elem}
+		}
+		return []any{}
+	case reflect.Map:
+		return map[string]any{}
+	case reflect.Interface, reflect.Chan, reflect.Func:
+		return nil
+	default:
+		return reflect.Zero(t).Interface()
+	}
+}
+
+var (
+	marshalerType     = reflect.TypeFor[json.Marshaler]()
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+)
+
+// jsonField is a field of a struct type as encoding/json sees it.
+type jsonField struct {
+	name   string
+	typ    reflect.Type
+	depth  int  // how deeply the field is embedded; 0 for t's own fields
+	tagged bool // the name comes from a json tag
+}
+
+// jsonFields returns the JSON object keys encoding/json produces for t,
+// including fields promoted from embedded structs, with their types. Name
+// clashes are resolved as encoding/json does: the least embedded field
+// wins, then a tagged one; a clash that remains drops the name.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	// Walk the embedded structs breadth-first, so each is visited at its
+	// shallowest depth.
+	var all []jsonField
+	level := []reflect.Type{t}
+	visited := make(map[reflect.Type]bool)
+	for depth := 0; len(level) > 0; depth++ {
+		count := make(map[reflect.Type]int)
+		for _, st := range level {
+			count[st]++
+		}
+		var next []reflect.Type
+		for _, st := range level {
+			if visited[st] {
+				continue
+			}
+			visited[st] = true
+			for f := range st.Fields() {
+				ft := f.Type
+				if ft.Kind() == reflect.Pointer {
+					ft = ft.Elem()
+				}
+				if f.Anonymous {
+					if !f.IsExported() && ft.Kind() != reflect.Struct {
+						continue
+					}
+				} else if !f.IsExported() {
+					continue
+				}
+				tag := f.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+				name, _, _ := strings.Cut(tag, ",")
+				if name == "" && f.Anonymous && ft.Kind() == reflect.Struct {
+					next = append(next, ft)
+					continue
+				}
+				field := jsonField{name: name, typ: f.Type, depth: depth, tagged: name != ""}
+				if name == "" {
+					field.name = f.Name
+				}
+				all = append(all, field)
+				if count[st] > 1 {
+					// Embedded twice at this depth: the copies clash.
+					all = append(all, field)
+				}
+			}
+		}
+		level = next
+	}
+
+	byName := make(map[string][]jsonField)
+	for _, f := range all {
+		byName[f.name] = append(byName[f.name], f)
+	}
+	fields := make(map[string]reflect.Type)
+	for name, candidates := range byName {
+		slices.SortStableFunc(candidates, func(a, b jsonField) int {
+			if a.depth != b.depth {
+				return a.depth - b.depth
+			}
+			switch {
+			case a.tagged == b.tagged:
+				return 0
+			case a.tagged:
+				return -1
+			default:
+				return 1
+			}
+		})
+		if len(candidates) > 1 && candidates[0].depth == candidates[1].depth && candidates[0].tagged == candidates[1].tagged {
+			continue
+		}
+		fields[name] = candidates[0].typ
+	}
+	return fields
+}
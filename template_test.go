@@ -0,0 +1,197 @@
+package typst
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type invoiceData struct {
+	Number   string `json:"number"`
+	Customer string `json:"customer"`
+	Items    []struct {
+		Name  string  `json:"name"`
+		Price float64 `json:"price"`
+	} `json:"items"`
+}
+
+const invoiceTemplate = `#let data = json("/data.json")
+= Invoice #data.number
+
+Billed to #data.customer, #data.items.len() items:
+
+#for item in data.items [
+  - #item.name: #item.price
+]
+`
+
+func TestTemplate_Render(t *testing.T) {
+	c := newTestCompiler(t)
+	tmpl, err := NewTemplate[invoiceData](c, []byte(invoiceTemplate))
+	if err != nil {
+		t.Fatalf("NewTemplate failed: %v", err)
+	}
+
+	var data invoiceData
+	data.Number = "2024-001"
+	data.Customer = "ACME"
+	data.Items = append(data.Items, struct {
+		Name  string  `json:"name"`
+		Price float64 `json:"price"`
+	}{"Widget", 9.5})
+	doc, err := tmpl.Render(context.Background(), data, WithLayout())
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	defer doc.Close()
+
+	if !bytes.HasPrefix(doc.Bytes(), []byte("%PDF-")) {
+		t.Fatal("output does not look like a PDF")
+	}
+	text, err := doc.Text(0)
+	if err != nil {
+		t.Fatalf("Text failed: %v", err)
+	}
+	for _, want := range []string{"Invoice 2024-001", "Billed to ACME", "Widget: 9.5"} {
+		if !strings.Contains(text.Text, want) {
+			t.Fatalf("rendered text lacks %q:\n%s", want, text.Text)
+		}
+	}
+}
+
+func TestTemplate_missingField(t *testing.T) {
+	c := newTestCompiler(t)
+	for _, tc := range []struct{ from, to, key string }{
+		{"data.customer", "data.client", "client"},
+		{"item.name", "item.title", "title"}, // nested in a loop
+	} {
+		source := strings.Replace(invoiceTemplate, tc.from, tc.to, 1)
+		_, err := NewTemplate[invoiceData](c, []byte(source))
+		var ce *CompileError
+		if !errors.As(err, &ce) {
+			t.Fatalf("expected CompileError for %s, got %v", tc.to, err)
+		}
+		if !strings.Contains(err.Error(), tc.key) || len(ce.Diagnostics) == 0 || ce.Diagnostics[0].Line == 0 {
+			t.Fatalf("error does not point at %s: %v %+v", tc.to, err, ce.Diagnostics)
+		}
+	}
+}
+
+func TestTemplate_canceledContext(t *testing.T) {
+	c := newTestCompiler(t)
+	tmpl, err := NewTemplate[invoiceData](c, []byte(invoiceTemplate))
+	if err != nil {
+		t.Fatalf("NewTemplate failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := tmpl.Render(ctx, invoiceData{}); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestSampleValue(t *testing.T) {
+	type node struct {
+		Name     string    `json:"name"`
+		When     time.Time `json:"when"`
+		Children []node    `json:"children"`
+		Parent   *node     `json:"parent,omitempty"`
+		Tags     map[string]string
+		skipped  int
+	}
+	got, err := json.Marshal(sampleValue(reflect.TypeFor[node](), make(map[reflect.Type]bool)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"Tags":{},"children":[],"name":"","parent":null,"when":"0001-01-01T00:00:00Z"}`
+	if string(got) != want {
+		t.Fatalf("sampleValue = %s, want %s", got, want)
+	}
+}
+
+func TestNewTemplate_withoutTemplateCheck(t *testing.T) {
+	type ratio struct {
+		Count int `json:"count"`
+	}
+	c := newTestCompiler(t)
+	source := []byte("#let data = json(\"/data.json\")\n#(12 / data.count)")
+	// The sample's zero count makes the check divide by zero.
+	if _, err := NewTemplate[ratio](c, source); err == nil {
+		t.Fatal("expected the sample value to fail the check")
+	}
+	tmpl, err := NewTemplate[ratio](c, source, WithoutTemplateCheck())
+	if err != nil {
+		t.Fatalf("NewTemplate without check failed: %v", err)
+	}
+	doc, err := tmpl.Render(context.Background(), ratio{Count: 4})
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	doc.Close()
+}
+
+type jsonInner struct {
+	Name    string
+	Caption int `json:"Label"`
+	Title   int
+	Twice   string
+}
+
+type jsonOther struct {
+	Name  string
+	Label string
+	Code  string `json:"code"`
+	Twice string `json:"Twice"`
+}
+
+type jsonCoded struct {
+	Code string `json:"code"`
+}
+
+type jsonOuter struct {
+	jsonInner
+	*jsonOther
+	jsonCoded
+	Title  string
+	hidden int
+	Skip   string `json:"-"`
+}
+
+func TestJSONFields(t *testing.T) {
+	fields := jsonFields(reflect.TypeFor[jsonOuter]())
+
+	// The keys are exactly those encoding/json writes.
+	data, err := json.Marshal(jsonOuter{jsonOther: &jsonOther{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var encoded map[string]any
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != len(encoded) {
+		t.Fatalf("jsonFields = %v, encoding/json writes %s", fields, data)
+	}
+	for name := range encoded {
+		if _, ok := fields[name]; !ok {
+			t.Fatalf("jsonFields lacks %q, encoding/json writes %s", name, data)
+		}
+	}
+
+	// And they have the type of the field encoding/json picks.
+	for name, want := range map[string]reflect.Type{
+		"Title": reflect.TypeFor[string](), // the outer field beats the embedded one
+		"Label": reflect.TypeFor[int](),    // the tagged field beats the untagged one
+		"Twice": reflect.TypeFor[string](),
+	} {
+		if fields[name] != want {
+			t.Errorf("jsonFields[%q] = %v, want %v", name, fields[name], want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package typst
+
+/*
+#include "typst_ffi.h"
+*/
+import "C"
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// traceMu serializes traced compilations: Typst records timing spans in
+// process-wide state.
+var traceMu sync.Mutex
+
+// Timings is the wall-clock time a compilation spent in each phase.
+type Timings struct {
+	Parse  time.Duration // parsing the main source and preamble; imports are parsed during Eval
+	Eval   time.Duration // evaluating the main module, imports included
+	Layout time.Duration // layout and introspection, reusing the evaluated module
+	PDF    time.Duration // PDF export; zero if no PDF was produced
+}
+
+// Total returns the sum of all phases.
+func (t Timings) Total() time.Duration {
+	return t.Parse + t.Eval + t.Layout + t.PDF
+}
+
+// Timings returns how long each phase of the compilation took. Returns the
+// zero value after Close.
+func (d *Document) Timings() Timings {
+	var ns struct {
+		Parse  int64 `json:"parse"`
+		Eval   int64 `json:"eval"`
+		Layout int64 `json:"layout"`
+		PDF    int64 `json:"pdf"`
+	}
+	if !d.inspect(func(h *C.TypstDocument) C.TypstResult { return C.typst_document_timings(h) }, &ns) {
+		return Timings{}
+	}
+	return Timings{
+		Parse:  time.Duration(ns.Parse),
+		Eval:   time.Duration(ns.Eval),
+		Layout: time.Duration(ns.Layout),
+		PDF:    time.Duration(ns.PDF),
+	}
+}
+
+// WithTrace records Typst's timing spans during the compilation and writes
+// them to w as Chrome trace-event JSON, viewable in chrome://tracing or
+// Perfetto. Spans name the Typst function and source line they cover, so a
+// slow template can be profiled down to the function.
+//
+// Typst records spans process-wide, so traced compilations run one at a
+// time, and untraced compilations running concurrently may show up in the
+// trace. The trace is written whether or not compilation succeeds, so a
+// template that fails after a long time can be profiled too.
+func WithTrace(w io.Writer) CompileOption {
+	return func(c *compileConfig) {
+		c.trace = w
+	}
+}
+
+// writeTrace writes the trace recorded during compilation to w.
+func (d *Document) writeTrace(w io.Writer) error {
+	data := takeBytes(C.typst_document_trace(d.handle))
+	_, err := w.Write(data)
+	return err
+}
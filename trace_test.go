@@ -0,0 +1,61 @@
+package typst
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+const slowTemplate = `#let fib(n) = if n < 2 { n } else { fib(n - 1) + fib(n - 2) }
+#table(columns: 4, ..range(200).map(i => str(fib(calc.rem(i, 12)))))`
+
+func TestDocument_Timings(t *testing.T) {
+	doc := compileTest(t, slowTemplate)
+	tm := doc.Timings()
+	if tm.Eval <= 0 || tm.Layout <= 0 || tm.PDF <= 0 {
+		t.Fatalf("expected non-zero eval, layout and PDF timings: %+v", tm)
+	}
+	if tm.Total() < tm.Layout {
+		t.Fatalf("Total %v less than layout %v", tm.Total(), tm.Layout)
+	}
+
+	doc.Close()
+	if doc.Timings() != (Timings{}) {
+		t.Fatal("expected zero timings after Close")
+	}
+}
+
+func TestWithTrace(t *testing.T) {
+	var buf bytes.Buffer
+	compileTest(t, slowTemplate, WithTrace(&buf))
+
+	var events []struct {
+		Name string `json:"name"`
+		Ph   string `json:"ph"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &events); err != nil {
+		t.Fatalf("trace is not a JSON event array: %v", err)
+	}
+	if len(events) == 0 {
+		t.Fatal("expected trace events")
+	}
+}
+
+func TestWithTrace_failedCompile(t *testing.T) {
+	var buf bytes.Buffer
+	c := newTestCompiler(t)
+	_, err := c.CompileBytes([]byte(slowTemplate+"\n#panic(\"late failure\")"), WithTrace(&buf))
+	var ce *CompileError
+	if !errors.As(err, &ce) {
+		t.Fatalf("expected CompileError, got %v", err)
+	}
+
+	var events []json.RawMessage
+	if err := json.Unmarshal(buf.Bytes(), &events); err != nil {
+		t.Fatalf("trace of failed compile is not a JSON event array: %v", err)
+	}
+	if len(events) == 0 {
+		t.Fatal("expected trace events for the failed compile")
+	}
+}
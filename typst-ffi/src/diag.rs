@@ -0,0 +1,257 @@
+//! Structured diagnostics, returned to Go as JSON in error results.
+
+use std::collections::HashMap;
+use std::fmt::Write;
+
+use serde_json::json;
+use typst::diag::{Severity, SourceDiagnostic};
+use typst::syntax::{ast, FileId, Source, Span, SyntaxNode};
+use typst::World;
+
+use crate::{make_error, SingleSourceWorld, TypstResult};
+
+/// Path reported for diagnostics that point into the compile preamble.
+const PREAMBLE_PATH: &str = "<preamble>";
+
+/// A resolved source position: 1-based line and column in a file.
+/// An empty path denotes the main source.
+pub(crate) struct SourceLocation {
+    pub path: String,
+    pub line: usize,
+    pub column: usize,
+}
+
+/// The sources of a compilation, for resolving spans once the world is gone.
+pub(crate) struct SourceMap {
+    main: Source,
+    others: HashMap<FileId, Source>,
+    preamble_len: usize,
+    preamble_lines: usize,
+}
+
+impl SourceMap {
+    /// Like [`SingleSourceWorld::locate_at`], for the sources loaded during
+    /// compilation.
+    pub(crate) fn locate_at(&self, span: Span, offset: usize) -> Option<SourceLocation> {
+        let id = span.id()?;
+        let source = if id == self.main.id() {
+            &self.main
+        } else {
+            self.others.get(&id)?
+        };
+        locate_in(
+            source,
+            self.main.id(),
+            self.preamble_len,
+            self.preamble_lines,
+            span,
+            offset,
+        )
+    }
+}
+
+impl SingleSourceWorld<'_> {
+    /// The main source and every source loaded so far.
+    pub(crate) fn source_map(&self) -> SourceMap {
+        SourceMap {
+            main: self.source.clone(),
+            others: self.sources.lock().unwrap().clone(),
+            preamble_len: self.preamble_len,
+            preamble_lines: self.preamble_lines,
+        }
+    }
+
+    /// Resolve a span to a source location. Positions in the main source are
+    /// reported relative to the caller's text, not the prepended preamble.
+    pub(crate) fn locate(&self, span: Span) -> Option<SourceLocation> {
+        self.locate_at(span, 0)
+    }
+
+    /// Like [`locate`](Self::locate), for a byte offset into the span's text.
+    pub(crate) fn locate_at(&self, span: Span, offset: usize) -> Option<SourceLocation> {
+        let source = self.source(span.id()?).ok()?;
+        locate_in(
+            &source,
+            self.source.id(),
+            self.preamble_len,
+            self.preamble_lines,
+            span,
+            offset,
+        )
+    }
+}
+
+/// Resolve a span, and a byte offset into its text, within `source`.
+/// Positions in the main source `main` are shifted past its preamble.
+fn locate_in(
+    source: &Source,
+    main: FileId,
+    preamble_len: usize,
+    preamble_lines: usize,
+    span: Span,
+    offset: usize,
+) -> Option<SourceLocation> {
+    let id = source.id();
+    let start = source.range(span)?.start + offset;
+    let lines = source.lines();
+    let mut line = lines.byte_to_line(start)?;
+    let column = lines.byte_to_column(start)?;
+
+    let path = if id != main {
+        display_path(id)
+    } else if start < preamble_len {
+        PREAMBLE_PATH.to_string()
+    } else {
+        line -= preamble_lines;
+        String::new()
+    };
+
+    Some(SourceLocation {
+        path,
+        line: line + 1,
+        column: column + 1,
+    })
+}
+
+/// Format a file id the way Typst does: `@ns/name:version/path` for package
+/// files, the root-relative path otherwise.
+pub(crate) fn display_path(id: FileId) -> String {
+    let path = id.vpath().as_rootless_path().display();
+    match id.package() {
+        Some(package) => format!("{}/{}", package, path),
+        None => path.to_string(),
+    }
+}
+
+/// Build an error result from compiler warnings and errors. Errors are
+/// labelled with `prefix` in the plain-text message.
+pub(crate) fn failure(
+    world: &SingleSourceWorld,
+    warnings: &[SourceDiagnostic],
+    errors: &[SourceDiagnostic],
+    prefix: &str,
+) -> TypstResult {
+    make_error(failure_payload(world, warnings, errors, prefix).to_string())
+}
+
+/// The JSON payload of [`failure`], for callers that add to it.
+pub(crate) fn failure_payload(
+    world: &SingleSourceWorld,
+    warnings: &[SourceDiagnostic],
+    errors: &[SourceDiagnostic],
+    prefix: &str,
+) -> serde_json::Value {
+    let mut msg = String::with_capacity((warnings.len() + errors.len()) * 64);
+    for w in warnings {
+        let _ = write!(msg, "warning: {}\n", w.message);
+    }
+    for err in errors {
+        let _ = write!(msg, "{}: {}\n", prefix, err.message);
+    }
+
+    let diagnostics: Vec<_> = warnings
+        .iter()
+        .chain(errors)
+        .map(|d| to_json(world, d))
+        .collect();
+    let mut payload = json!({
+        "message": msg,
+        "diagnostics": diagnostics,
+    });
+    // Lets the caller download the packages and retry.
+    let missing = world.missing_packages();
+    if !missing.is_empty() {
+        payload["missing_packages"] = json!(missing);
+    }
+    payload
+}
+
+/// Build an error result carrying a plain-text message and structured diagnostics.
+pub(crate) fn error(message: String, diagnostics: Vec<serde_json::Value>) -> TypstResult {
+    make_error(error_payload(message, diagnostics).to_string())
+}
+
+/// The JSON payload of [`error`], for callers that add to it.
+pub(crate) fn error_payload(
+    message: String,
+    diagnostics: Vec<serde_json::Value>,
+) -> serde_json::Value {
+    json!({
+        "message": message,
+        "diagnostics": diagnostics,
+    })
+}
+
+/// Convert a diagnostic to its JSON form, resolving its span.
+pub(crate) fn to_json(world: &SingleSourceWorld, diag: &SourceDiagnostic) -> serde_json::Value {
+    let severity = match diag.severity {
+        Severity::Error => "error",
+        Severity::Warning => "warning",
+    };
+    let mut value = json!({
+        "severity": severity,
+        "message": diag.message.as_str(),
+        "hints": diag.hints.iter().map(|h| h.as_str()).collect::<Vec<_>>(),
+    });
+    if let Some(loc) = world.locate(diag.span) {
+        value["path"] = json!(loc.path);
+        value["line"] = json!(loc.line);
+        value["column"] = json!(loc.column);
+    }
+    if diag.severity == Severity::Error {
+        if let Some(key) = citation_key(world, diag) {
+            value["citation_key"] = json!(key);
+        }
+    }
+    value
+}
+
+/// The key of the citation whose lookup failed, if that is what the error
+/// reports: a `cite(<key>)` call with a key missing from the bibliography,
+/// or an `@key` reference that resolves neither to a label nor, with a
+/// bibliography in the document, to an entry. Typst tries both for `@key`,
+/// so without a bibliography it is a plain reference and not reported.
+fn citation_key(world: &SingleSourceWorld, diag: &SourceDiagnostic) -> Option<String> {
+    if !diag.message.contains("does not exist") {
+        return None;
+    }
+    let source = world.source(diag.span.id()?).ok()?;
+    let mut node = source.find(diag.span)?;
+    loop {
+        if let Some(reference) = node.cast::<ast::Ref>() {
+            let key = reference.target();
+            let failed = diag.message.contains(key) && has_bibliography(world);
+            return failed.then(|| key.to_string());
+        }
+        if let Some(call) = node.cast::<ast::FuncCall>() {
+            if !is_call_to(call, "cite") {
+                return None;
+            }
+            let key = call.args().items().find_map(|arg| match arg {
+                ast::Arg::Pos(ast::Expr::Label(label)) => Some(label.get().to_string()),
+                _ => None,
+            })?;
+            let failed = diag.message.contains("bibliography") && diag.message.contains(&*key);
+            return failed.then_some(key);
+        }
+        node = node.parent()?.clone();
+    }
+}
+
+/// Whether any source loaded by the compilation calls `bibliography`.
+fn has_bibliography(world: &SingleSourceWorld) -> bool {
+    fn calls_bibliography(node: &SyntaxNode) -> bool {
+        node.cast::<ast::FuncCall>()
+            .is_some_and(|call| is_call_to(call, "bibliography"))
+            || node.children().any(calls_bibliography)
+    }
+    let sources = world.sources.lock().unwrap();
+    std::iter::once(&world.source)
+        .chain(sources.values())
+        .any(|source| calls_bibliography(source.root()))
+}
+
+/// Whether `call` calls the function named `name` directly.
+fn is_call_to(call: ast::FuncCall, name: &str) -> bool {
+    matches!(call.callee(), ast::Expr::Ident(callee) if callee.as_str() == name)
+}
@@ -0,0 +1,600 @@
+//! Compiled documents: the exported PDF together with what Go can inspect
+//! after compilation. Metadata, pages and outline are extracted up front;
+//! the layout itself, which holds every frame and glyph, is kept only on
+//! request, together with the sources needed to resolve its spans.
+
+use std::collections::HashMap;
+
+use serde_json::json;
+use typst::ecow::EcoString;
+use typst::foundations::{Content, Datetime, Element, Label, Selector, Smart, StyleChain};
+use typst::layout::{Abs, Frame, FrameItem, PagedDocument, Point, Position, Transform};
+use typst::model::{Destination, DocumentInfo, HeadingElem, Numbering};
+use typst::text::{Font, FontStyle};
+
+use typst::utils::PicoStr;
+
+use crate::diag::SourceMap;
+use crate::timing::Timings;
+use crate::{make_data, make_error, TypstResult, TypstSlice};
+
+/// A compiled document. Owns the PDF bytes handed to Go.
+pub struct TypstDocument {
+    pdf: Vec<u8>,
+    /// Metadata, pages and outline as JSON, extracted at compile time.
+    metadata: String,
+    pages: String,
+    outline: String,
+    page_count: usize,
+    /// The laid-out document, if it was kept for text, link, font and glyph
+    /// inspection.
+    layout: Option<Layout>,
+    extras: CompileExtras,
+}
+
+/// A laid-out document with the sources its spans point into.
+struct Layout {
+    document: PagedDocument,
+    sources: SourceMap,
+}
+
+/// Results gathered during compilation alongside the layout, while the world
+/// was still alive.
+#[derive(Default)]
+pub(crate) struct CompileExtras {
+    /// Query results as a JSON array, if a query ran with the compilation.
+    pub query: Option<String>,
+    /// Time spent in each compile phase.
+    pub timings: Timings,
+    /// Chrome trace-event JSON, if tracing was requested.
+    pub trace: Option<Vec<u8>>,
+}
+
+impl TypstDocument {
+    /// Extract what Go can inspect from the document, move it and the PDF
+    /// onto the heap and return them as a successful result whose data
+    /// points at the PDF bytes. The layout is kept, to be resolved against
+    /// `sources`, only if `sources` is given.
+    pub(crate) fn into_result(
+        pdf: Vec<u8>,
+        document: PagedDocument,
+        sources: Option<SourceMap>,
+        extras: CompileExtras,
+    ) -> TypstResult {
+        let doc = Box::into_raw(Box::new(TypstDocument {
+            pdf,
+            metadata: metadata_json(&document),
+            pages: pages_json(&document),
+            outline: outline_json(&document),
+            page_count: document.pages.len(),
+            layout: sources.map(|sources| Layout { document, sources }),
+            extras,
+        }));
+        // The Vec is never modified again, so its buffer stays put until
+        // typst_document_free.
+        let pdf = unsafe { &mut (*doc).pdf };
+        TypstResult {
+            data: pdf.as_mut_ptr(),
+            len: pdf.len(),
+            error: 0,
+            doc,
+        }
+    }
+}
+
+/// Effective document metadata as JSON.
+fn metadata_json(document: &PagedDocument) -> String {
+    let info = &document.info;
+    let mut value = json!({
+        "title": info.title.as_deref(),
+        "authors": info.author.iter().map(|a| a.as_str()).collect::<Vec<_>>(),
+        "subject": info.description.as_deref(),
+        "keywords": info.keywords.iter().map(|k| k.as_str()).collect::<Vec<_>>(),
+    });
+    if let Smart::Custom(Some(date)) = &info.date {
+        value["date"] = json!(format_datetime(date));
+    }
+    value.to_string()
+}
+
+/// Page count, sizes and labels as JSON.
+fn pages_json(document: &PagedDocument) -> String {
+    let pages: Vec<_> = document
+        .pages
+        .iter()
+        .map(|page| {
+            let size = page.frame.size();
+            // Labels follow the page's numbering pattern, as in the PDF
+            // page labels; function numberings have no static label.
+            let label = match &page.numbering {
+                Some(Numbering::Pattern(pattern)) => pattern.apply(&[page.number as _]).to_string(),
+                _ => String::new(),
+            };
+            json!({
+                "width": size.x.to_pt(),
+                "height": size.y.to_pt(),
+                "number": page.number,
+                "label": label,
+            })
+        })
+        .collect();
+    serde_json::Value::Array(pages).to_string()
+}
+
+/// Headings in document order as a flat JSON array; Go nests them by level.
+fn outline_json(document: &PagedDocument) -> String {
+    let introspector = &document.introspector;
+    let selector = Selector::Elem(Element::of::<HeadingElem>(), None);
+    let entries: Vec<_> = introspector
+        .query(&selector)
+        .iter()
+        .filter_map(|elem| {
+            let heading = elem.to_packed::<HeadingElem>()?;
+            let mut entry = json!({
+                "level": heading.resolve_level(StyleChain::default()).get(),
+                "title": heading.body.plain_text().as_str(),
+                "label": label_of(elem),
+            });
+            entry["position"] = position_json(&introspector.position(elem.location()?));
+            Some(entry)
+        })
+        .collect();
+    serde_json::Value::Array(entries).to_string()
+}
+
+/// Fonts used by text runs as a JSON array, in order of first use, with
+/// the number of glyphs set in each.
+fn fonts_json(document: &PagedDocument) -> String {
+    let mut fonts: Vec<(&Font, usize)> = Vec::new();
+    let mut index: HashMap<&Font, usize> = HashMap::new();
+    for page in &document.pages {
+        for_each_item(&page.frame, Transform::identity(), &mut |_, _, item| {
+            let FrameItem::Text(text) = item else {
+                return;
+            };
+            let i = *index.entry(&text.font).or_insert_with(|| {
+                fonts.push((&text.font, 0));
+                fonts.len() - 1
+            });
+            fonts[i].1 += text.glyphs.len();
+        });
+    }
+    let fonts: Vec<_> = fonts
+        .into_iter()
+        .map(|(font, glyphs)| {
+            let info = font.info();
+            let style = match info.variant.style {
+                FontStyle::Normal => "normal",
+                FontStyle::Italic => "italic",
+                FontStyle::Oblique => "oblique",
+            };
+            json!({
+                "family": info.family.as_str(),
+                "style": style,
+                "weight": info.variant.weight.to_number(),
+                "glyphs": glyphs,
+            })
+        })
+        .collect();
+    serde_json::Value::Array(fonts).to_string()
+}
+
+/// Positions of all elements carrying `label` as a JSON array, in
+/// document order. Unknown or invalid labels yield an empty array.
+fn locate_json(document: &PagedDocument, label: &str) -> String {
+    let introspector = &document.introspector;
+    let positions: Vec<_> = match Label::new(PicoStr::intern(label)) {
+        Some(label) => introspector
+            .query(&Selector::Label(label))
+            .iter()
+            .filter_map(|elem| {
+                let loc = elem.location()?;
+                Some(position_json(&introspector.position(loc)))
+            })
+            .collect(),
+        None => Vec::new(),
+    };
+    serde_json::Value::Array(positions).to_string()
+}
+
+/// Plain text of a page in reading order: text runs on one baseline are
+/// joined, with a space where there is a gap, and lines end with '\n'.
+fn page_text(document: &PagedDocument, index: usize) -> Option<String> {
+    let frame = &document.pages.get(index)?.frame;
+    let mut out = String::new();
+    // Baseline, end x and font size of the previous text run.
+    let mut last: Option<(Abs, Abs, Abs)> = None;
+    for_each_item(frame, Transform::identity(), &mut |ts, pos, item| {
+        let FrameItem::Text(text) = item else {
+            return;
+        };
+        let start = pos.transform(ts);
+        let end = Point::new(pos.x + text.width(), pos.y).transform(ts);
+        if let Some((baseline, end_x, size)) = last {
+            if (start.y - baseline).abs() > size * 0.5 {
+                out.push('\n');
+            } else if start.x - end_x > size * 0.1
+                && !out.ends_with(' ')
+                && !text.text.starts_with(' ')
+            {
+                out.push(' ');
+            }
+        }
+        out.push_str(&text.text);
+        last = Some((start.y, end.x, text.size));
+    });
+    if last.is_some() {
+        out.push('\n');
+    }
+    Some(out)
+}
+
+/// Link areas with their destinations as a JSON array, in page order.
+fn links_json(document: &PagedDocument) -> String {
+    let introspector = &document.introspector;
+    let mut links = Vec::new();
+    for (index, page) in document.pages.iter().enumerate() {
+        for_each_item(&page.frame, Transform::identity(), &mut |ts, pos, item| {
+            let FrameItem::Link(dest, size) = item else {
+                return;
+            };
+            // Bounding box of the (possibly rotated or scaled) link area.
+            let corners = [
+                pos,
+                Point::new(pos.x + size.x, pos.y),
+                Point::new(pos.x, pos.y + size.y),
+                Point::new(pos.x + size.x, pos.y + size.y),
+            ]
+            .map(|p| p.transform(ts));
+            let min = corners
+                .iter()
+                .fold(corners[0], |a, b| Point::new(a.x.min(b.x), a.y.min(b.y)));
+            let max = corners
+                .iter()
+                .fold(corners[0], |a, b| Point::new(a.x.max(b.x), a.y.max(b.y)));
+
+            let mut link = json!({
+                "page": index,
+                "rect": {
+                    "x": min.x.to_pt(),
+                    "y": min.y.to_pt(),
+                    "width": (max.x - min.x).to_pt(),
+                    "height": (max.y - min.y).to_pt(),
+                },
+            });
+            match dest {
+                Destination::Url(url) => link["url"] = json!(url.as_str()),
+                Destination::Position(target) => link["target"] = position_json(target),
+                Destination::Location(loc) => {
+                    link["target"] = position_json(&introspector.position(*loc))
+                }
+            }
+            links.push(link);
+        });
+    }
+    serde_json::Value::Array(links).to_string()
+}
+
+/// The label attached to an element, without angle brackets.
+fn label_of(elem: &Content) -> Option<String> {
+    elem.label()
+        .map(|label| label.resolve().as_str().to_string())
+}
+
+/// Convert a Typst position (1-based page) to JSON with a 0-based page index.
+pub(crate) fn position_json(pos: &Position) -> serde_json::Value {
+    json!({
+        "page": pos.page.get() - 1,
+        "x": pos.point.x.to_pt(),
+        "y": pos.point.y.to_pt(),
+    })
+}
+
+/// Visit every non-group item of a frame, in order, with the transform from
+/// the frame's coordinate system to the page and the item's position.
+pub(crate) fn for_each_item<'a>(
+    frame: &'a Frame,
+    ts: Transform,
+    f: &mut impl FnMut(Transform, Point, &'a FrameItem),
+) {
+    for (pos, item) in frame.items() {
+        match item {
+            FrameItem::Group(group) => {
+                let ts = ts
+                    .pre_concat(Transform::translate(pos.x, pos.y))
+                    .pre_concat(group.transform);
+                for_each_item(&group.frame, ts, f);
+            }
+            _ => f(ts, *pos, item),
+        }
+    }
+}
+
+/// Find the code points no font could render: with every font and fallback
+/// exhausted, Typst sets them with the .notdef glyph (id 0) of the last font
+/// tried, which shows as tofu.
+fn missing_glyphs(layout: &Layout) -> Vec<serde_json::Value> {
+    let mut missing = Vec::new();
+    for (index, page) in layout.document.pages.iter().enumerate() {
+        for_each_item(&page.frame, Transform::identity(), &mut |_, _, item| {
+            let FrameItem::Text(text) = item else {
+                return;
+            };
+            for glyph in text.glyphs.iter().filter(|g| g.id == 0) {
+                let (span, offset) = glyph.span;
+                let cluster = &text.text[glyph.range()];
+                for (at, c) in cluster.char_indices() {
+                    let mut entry = json!({
+                        "char": c as u32,
+                        "page": index,
+                    });
+                    if let Some(loc) = layout.sources.locate_at(span, offset as usize + at) {
+                        entry["path"] = json!(loc.path);
+                        entry["line"] = json!(loc.line);
+                        entry["column"] = json!(loc.column);
+                    }
+                    missing.push(entry);
+                }
+            }
+        });
+    }
+    missing
+}
+
+/// Override document metadata with the non-empty fields of a JSON object.
+pub(crate) fn apply_metadata(info: &mut DocumentInfo, json: &str) -> Result<(), String> {
+    let value: serde_json::Value =
+        serde_json::from_str(json).map_err(|e| format!("invalid metadata: {}", e))?;
+    let string = |key: &str| {
+        value[key]
+            .as_str()
+            .filter(|s| !s.is_empty())
+            .map(EcoString::from)
+    };
+    let list = |key: &str| -> Vec<EcoString> {
+        value[key]
+            .as_array()
+            .map(|items| {
+                items
+                    .iter()
+                    .filter_map(|v| v.as_str())
+                    .map(EcoString::from)
+                    .collect()
+            })
+            .unwrap_or_default()
+    };
+
+    if let Some(title) = string("title") {
+        info.title = Some(title);
+    }
+    if let Some(subject) = string("subject") {
+        info.description = Some(subject);
+    }
+    let authors = list("authors");
+    if !authors.is_empty() {
+        info.author = authors;
+    }
+    let keywords = list("keywords");
+    if !keywords.is_empty() {
+        info.keywords = keywords;
+    }
+    if let Some(date) = string("date") {
+        info.date = Smart::Custom(Some(parse_date(&date)?));
+    }
+    Ok(())
+}
+
+/// Parse a YYYY-MM-DD date.
+fn parse_date(s: &str) -> Result<Datetime, String> {
+    let invalid = || format!("invalid date {:?}, expected YYYY-MM-DD", s);
+    let mut parts = s.splitn(3, '-');
+    let mut next = || parts.next().ok_or_else(invalid);
+    let year = next()?.parse().map_err(|_| invalid())?;
+    let month = next()?.parse().map_err(|_| invalid())?;
+    let day = next()?.parse().map_err(|_| invalid())?;
+    Datetime::from_ymd(year, month, day).ok_or_else(invalid)
+}
+
+/// Format a datetime as ISO 8601, with whichever of date and time it has.
+pub(crate) fn format_datetime(dt: &Datetime) -> String {
+    let date = match (dt.year(), dt.month(), dt.day()) {
+        (Some(y), Some(m), Some(d)) => Some(format!("{:04}-{:02}-{:02}", y, m, d)),
+        _ => None,
+    };
+    let time = match (dt.hour(), dt.minute(), dt.second()) {
+        (Some(h), Some(m), Some(s)) => Some(format!("{:02}:{:02}:{:02}", h, m, s)),
+        _ => None,
+    };
+    match (date, time) {
+        (Some(date), Some(time)) => format!("{}T{}", date, time),
+        (Some(date), None) => date,
+        (None, Some(time)) => time,
+        (None, None) => String::new(),
+    }
+}
+
+// ---------------------------------------------------------------------------
+// FFI
+// ---------------------------------------------------------------------------
+
+/// The error returned by accessors that need the layout when it was not kept.
+fn no_layout() -> TypstResult {
+    make_error("document was compiled without its layout".to_string())
+}
+
+/// Effective document metadata as a JSON object.
+///
+/// # Safety
+/// `doc` must be a valid pointer from a `TypstResult`.
+/// Free the result with `typst_free_result`.
+#[no_mangle]
+pub unsafe extern "C" fn typst_document_metadata(doc: *const TypstDocument) -> TypstResult {
+    let doc = unsafe { &*doc };
+    make_data(doc.metadata.clone().into_bytes())
+}
+
+/// Results of the query run with the compilation as a JSON array, or
+/// `null` if no query was given.
+///
+/// # Safety
+/// `doc` must be a valid pointer from a `TypstResult`.
+/// Free the result with `typst_free_result`.
+#[no_mangle]
+pub unsafe extern "C" fn typst_document_query(doc: *const TypstDocument) -> TypstResult {
+    let doc = unsafe { &*doc };
+    make_data(
+        doc.extras
+            .query
+            .as_deref()
+            .unwrap_or("null")
+            .as_bytes()
+            .to_vec(),
+    )
+}
+
+/// Page sizes (in points), numbers and labels as a JSON array.
+///
+/// # Safety
+/// `doc` must be a valid pointer from a `TypstResult`.
+/// Free the result with `typst_free_result`.
+#[no_mangle]
+pub unsafe extern "C" fn typst_document_pages(doc: *const TypstDocument) -> TypstResult {
+    let doc = unsafe { &*doc };
+    make_data(doc.pages.clone().into_bytes())
+}
+
+/// Headings in document order as a JSON array.
+///
+/// # Safety
+/// `doc` must be a valid pointer from a `TypstResult`.
+/// Free the result with `typst_free_result`.
+#[no_mangle]
+pub unsafe extern "C" fn typst_document_outline(doc: *const TypstDocument) -> TypstResult {
+    let doc = unsafe { &*doc };
+    make_data(doc.outline.clone().into_bytes())
+}
+
+/// Plain text of the page at a 0-based index, in reading order.
+/// Fails if the layout was not kept.
+///
+/// # Safety
+/// `doc` must be a valid pointer from a `TypstResult`.
+/// Free the result with `typst_free_result`.
+#[no_mangle]
+pub unsafe extern "C" fn typst_document_text(
+    doc: *const TypstDocument,
+    page: usize,
+) -> TypstResult {
+    let Some(Layout { document, .. }) = (unsafe { &*doc }).layout.as_ref() else {
+        return no_layout();
+    };
+    match page_text(document, page) {
+        Some(text) => make_data(text.into_bytes()),
+        None => make_error(format!("page {} out of range", page)),
+    }
+}
+
+/// Positions of the elements carrying a label, as a JSON array.
+/// Fails if the layout was not kept.
+///
+/// # Safety
+/// - `doc` must be a valid pointer from a `TypstResult`.
+/// - `label` must be a valid UTF-8 slice, without angle brackets.
+/// - Free the result with `typst_free_result`.
+#[no_mangle]
+pub unsafe extern "C" fn typst_document_locate(
+    doc: *const TypstDocument,
+    label: TypstSlice,
+) -> TypstResult {
+    let Some(Layout { document, .. }) = (unsafe { &*doc }).layout.as_ref() else {
+        return no_layout();
+    };
+    let label = unsafe { label.as_str() }.unwrap_or("");
+    make_data(locate_json(document, label).into_bytes())
+}
+
+/// Fonts used in the document with glyph counts, as a JSON array.
+/// Fails if the layout was not kept.
+///
+/// # Safety
+/// `doc` must be a valid pointer from a `TypstResult`.
+/// Free the result with `typst_free_result`.
+#[no_mangle]
+pub unsafe extern "C" fn typst_document_fonts(doc: *const TypstDocument) -> TypstResult {
+    let Some(Layout { document, .. }) = (unsafe { &*doc }).layout.as_ref() else {
+        return no_layout();
+    };
+    make_data(fonts_json(document).into_bytes())
+}
+
+/// Code points no font could render, with their source locations, as a
+/// JSON array. Fails if the layout was not kept.
+///
+/// # Safety
+/// `doc` must be a valid pointer from a `TypstResult`.
+/// Free the result with `typst_free_result`.
+#[no_mangle]
+pub unsafe extern "C" fn typst_document_missing_glyphs(doc: *const TypstDocument) -> TypstResult {
+    let Some(layout) = (unsafe { &*doc }).layout.as_ref() else {
+        return no_layout();
+    };
+    make_data(serde_json::to_vec(&missing_glyphs(layout)).unwrap_or_default())
+}
+
+/// Per-phase compile durations in nanoseconds, as a JSON object.
+///
+/// # Safety
+/// `doc` must be a valid pointer from a `TypstResult`.
+/// Free the result with `typst_free_result`.
+#[no_mangle]
+pub unsafe extern "C" fn typst_document_timings(doc: *const TypstDocument) -> TypstResult {
+    let doc = unsafe { &*doc };
+    make_data(doc.extras.timings.to_json().into_bytes())
+}
+
+/// Chrome trace-event JSON recorded during compilation; empty if tracing
+/// was not requested.
+///
+/// # Safety
+/// `doc` must be a valid pointer from a `TypstResult`.
+/// Free the result with `typst_free_result`.
+#[no_mangle]
+pub unsafe extern "C" fn typst_document_trace(doc: *const TypstDocument) -> TypstResult {
+    let doc = unsafe { &*doc };
+    make_data(doc.extras.trace.clone().unwrap_or_default())
+}
+
+/// Link areas and destinations as a JSON array.
+/// Fails if the layout was not kept.
+///
+/// # Safety
+/// `doc` must be a valid pointer from a `TypstResult`.
+/// Free the result with `typst_free_result`.
+#[no_mangle]
+pub unsafe extern "C" fn typst_document_links(doc: *const TypstDocument) -> TypstResult {
+    let Some(Layout { document, .. }) = (unsafe { &*doc }).layout.as_ref() else {
+        return no_layout();
+    };
+    make_data(links_json(document).into_bytes())
+}
+
+/// Number of pages.
+///
+/// # Safety
+/// `doc` must be a valid pointer from a `TypstResult`.
+#[no_mangle]
+pub unsafe extern "C" fn typst_document_page_count(doc: *const TypstDocument) -> usize {
+    let doc = unsafe { &*doc };
+    doc.page_count
+}
+
+/// Free a compiled document, including its PDF bytes.
+///
+/// # Safety
+/// `doc` must be a valid pointer from a `TypstResult`, or null.
+#[no_mangle]
+pub unsafe extern "C" fn typst_document_free(doc: *mut TypstDocument) {
+    if !doc.is_null() {
+        let _ = unsafe { Box::from_raw(doc) };
+    }
+}
@@ -0,0 +1,78 @@
+//! Evaluation of standalone Typst code, with the result returned to Go as JSON.
+
+use serde_json::json;
+use typst::comemo::Track;
+use typst::engine::Sink;
+use typst::foundations::{Scope, Value};
+use typst::syntax::{Span, SyntaxMode};
+use typst::World;
+
+use crate::document::format_datetime;
+use crate::{diag, make_data, SingleSourceWorld, TypstResult};
+
+/// Evaluate `code` in code mode against the world and return its value as JSON.
+/// The world's main source is the code itself, so diagnostics and relative
+/// imports resolve against it.
+pub(crate) fn eval(world: &SingleSourceWorld, code: &str) -> TypstResult {
+    let span = Span::from_range(world.main(), 0..0);
+    let tracked: &dyn World = world;
+    let mut sink = Sink::new();
+    let result = typst_eval::eval_string(
+        &typst::ROUTINES,
+        tracked.track(),
+        sink.track_mut(),
+        code,
+        span,
+        SyntaxMode::Code,
+        Scope::new(),
+    );
+    match result {
+        Ok(value) => make_data(value_to_json(&value).to_string().into_bytes()),
+        Err(errors) => diag::failure(world, &sink.warnings(), &errors, "eval error"),
+    }
+}
+
+/// Convert a Typst value to JSON. None, booleans, numbers, strings and arrays
+/// map directly; every other value becomes a one-key object naming its kind,
+/// so that no dictionary content can pass for a tag:
+///
+/// - `{"dict": {...}}` for dictionaries, and modules as dictionaries of their
+///   top-level bindings;
+/// - `{"datetime": "..."}` and `{"length": {"pt": ..., "em": ...}}`;
+/// - `{"other": ...}` for anything else, in Typst's own serialization as in
+///   `typst query`.
+fn value_to_json(value: &Value) -> serde_json::Value {
+    match value {
+        Value::None => serde_json::Value::Null,
+        Value::Bool(v) => json!(v),
+        Value::Int(v) => json!(v),
+        Value::Float(v) => json!(v),
+        Value::Str(v) => json!(v.as_str()),
+        Value::Array(array) => array.iter().map(value_to_json).collect(),
+        Value::Dict(dict) => {
+            let fields: serde_json::Map<String, serde_json::Value> = dict
+                .iter()
+                .map(|(key, v)| (key.to_string(), value_to_json(v)))
+                .collect();
+            json!({ "dict": fields })
+        }
+        Value::Module(module) => {
+            let fields: serde_json::Map<String, serde_json::Value> = module
+                .scope()
+                .iter()
+                .map(|(name, binding)| (name.to_string(), value_to_json(binding.read())))
+                .collect();
+            json!({ "dict": fields })
+        }
+        Value::Datetime(dt) => json!({ "datetime": format_datetime(dt) }),
+        Value::Length(length) => json!({
+            "length": {
+                "pt": length.abs.to_pt(),
+                "em": length.em.get(),
+            },
+        }),
+        other => json!({
+            "other": serde_json::to_value(other).unwrap_or(serde_json::Value::Null),
+        }),
+    }
+}
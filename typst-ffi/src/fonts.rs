@@ -0,0 +1,281 @@
+//! Font loading for compiler instances.
+//!
+//! Fonts are registered lazily, as in the typst CLI: at construction only
+//! the metadata needed for the `FontBook` is read, and a face is fully
+//! parsed the first time a lookup selects it. Fonts on disk are memory-mapped
+//! rather than copied, so large (e.g. CJK) fonts cost address space, not
+//! heap, until their pages are actually touched.
+//!
+//! A compiler's fonts live in an immutable `FontStore`. Adding or removing
+//! fonts builds a new store that shares the existing (possibly already
+//! parsed) slots, while running compilations keep the store they started with.
+
+use std::fs::File;
+use std::path::{Path, PathBuf};
+use std::sync::{Arc, OnceLock};
+
+use serde_json::json;
+use typst::foundations::Bytes;
+use typst::text::{Font, FontBook, FontFlags, FontInfo, FontStyle};
+use typst::utils::LazyHash;
+
+/// A font file supplied by Go: in-memory data, or a path read from disk
+/// when no data is given. The name identifies the file in warnings.
+pub(crate) struct FontFile<'a> {
+    pub name: &'a str,
+    pub data: Option<&'a [u8]>,
+}
+
+/// Where the data of a font face comes from.
+#[derive(Clone)]
+enum FontSource {
+    /// Bundled or caller-supplied bytes.
+    Memory(Bytes),
+    /// A font file, memory-mapped when the face is first loaded.
+    File(PathBuf),
+}
+
+/// Which font option supplied a face.
+#[derive(Clone)]
+enum FontOrigin {
+    Bundled,
+    /// The n-th custom font file, with its path or name.
+    Custom(usize, String),
+}
+
+/// A font face that is parsed on first use.
+pub(crate) struct FontSlot {
+    info: FontInfo,
+    source: FontSource,
+    origin: FontOrigin,
+    index: u32,
+    font: OnceLock<Option<Font>>,
+}
+
+impl FontSlot {
+    /// Load the face, parsing it on the first call. Returns `None` if the
+    /// file became unreadable or invalid after it was scanned.
+    pub(crate) fn get(&self) -> Option<Font> {
+        self.font
+            .get_or_init(|| {
+                let data = match &self.source {
+                    FontSource::Memory(bytes) => bytes.clone(),
+                    FontSource::File(path) => Bytes::new(map(path).ok()?),
+                };
+                Font::new(data, self.index)
+            })
+            .clone()
+    }
+}
+
+/// An immutable set of font faces and the book indexing them.
+pub(crate) struct FontStore {
+    pub book: LazyHash<FontBook>,
+    slots: Vec<Arc<FontSlot>>,
+    /// Number of custom font files supplied so far, to number their origins.
+    files: usize,
+}
+
+impl FontStore {
+    /// A store with the bundled fonts, or an empty one.
+    pub(crate) fn new(bundled: bool) -> Self {
+        // Bundled fonts typically yield ~20 faces.
+        let mut slots = Vec::with_capacity(20);
+        if bundled {
+            for data in typst_assets::fonts() {
+                let source = FontSource::Memory(Bytes::new(data));
+                register(source, FontOrigin::Bundled, data, &mut slots);
+            }
+        }
+        Self::from_slots(slots, 0)
+    }
+
+    fn from_slots(slots: Vec<Arc<FontSlot>>, files: usize) -> Self {
+        let mut book = FontBook::new();
+        for slot in &slots {
+            book.push(slot.info.clone());
+        }
+        FontStore {
+            book: LazyHash::new(book),
+            slots,
+            files,
+        }
+    }
+
+    /// Whether the store has no faces at all.
+    pub(crate) fn is_empty(&self) -> bool {
+        self.slots.is_empty()
+    }
+
+    /// Load the face at a book index.
+    pub(crate) fn font(&self, index: usize) -> Option<Font> {
+        self.slots.get(index)?.get()
+    }
+
+    /// Whether the book has a family (case-insensitive).
+    pub(crate) fn contains_family(&self, family: &str) -> bool {
+        // The book keys families by their lowercase names.
+        self.book.contains_family(&family.to_lowercase())
+    }
+
+    /// A new store with every face of the given font files added,
+    /// TrueType/OpenType collections (.ttc/.otc) included. Files that cannot
+    /// be read or contain no valid face are skipped with a warning.
+    pub(crate) fn with_files(&self, files: &[FontFile], warnings: &mut Vec<String>) -> Self {
+        // Each file usually contains 1-4 faces.
+        let mut slots = Vec::with_capacity(self.slots.len() + files.len() * 4);
+        slots.extend(self.slots.iter().cloned());
+        for (n, file) in files.iter().enumerate() {
+            let origin = FontOrigin::Custom(self.files + n, file.name.to_string());
+            let registered = match file.data {
+                Some(data) => {
+                    let bytes = Bytes::new(data.to_vec());
+                    register(
+                        FontSource::Memory(bytes.clone()),
+                        origin,
+                        &bytes,
+                        &mut slots,
+                    )
+                }
+                None => {
+                    let path = Path::new(file.name);
+                    match map(path) {
+                        Ok(mmap) => {
+                            register(FontSource::File(path.into()), origin, &mmap, &mut slots)
+                        }
+                        Err(e) => {
+                            warnings.push(format!("skipping font {}: {}", file.name, e));
+                            continue;
+                        }
+                    }
+                }
+            };
+            if registered == 0 {
+                warnings.push(format!(
+                    "skipping font {}: not a valid font file",
+                    display_name(file)
+                ));
+            }
+        }
+        Self::from_slots(slots, self.files + files.len())
+    }
+
+    /// A new store without the faces of the given families
+    /// (case-insensitive), and the number of faces removed.
+    pub(crate) fn without_families(&self, families: &[&str]) -> (Self, usize) {
+        let families: Vec<String> = families.iter().map(|f| f.to_lowercase()).collect();
+        let slots: Vec<_> = self
+            .slots
+            .iter()
+            .filter(|slot| !families.contains(&slot.info.family.to_lowercase()))
+            .cloned()
+            .collect();
+        let removed = self.slots.len() - slots.len();
+        (Self::from_slots(slots, self.files), removed)
+    }
+
+    /// Describe every face as a JSON array: book metadata, coverage as code
+    /// point ranges, and which option supplied it. Only variable fonts are
+    /// loaded, to read their axes.
+    pub(crate) fn catalog_json(&self) -> String {
+        let fonts: Vec<_> = self.slots.iter().map(|slot| slot_json(slot)).collect();
+        serde_json::Value::Array(fonts).to_string()
+    }
+}
+
+/// Add a slot for each face in `data`, read from `source`. Returns the
+/// number of faces found.
+fn register(
+    source: FontSource,
+    origin: FontOrigin,
+    data: &[u8],
+    slots: &mut Vec<Arc<FontSlot>>,
+) -> usize {
+    let faces: Vec<FontInfo> = (0..)
+        .map_while(|index| FontInfo::new(data, index))
+        .collect();
+    let count = faces.len();
+    for (index, info) in faces.into_iter().enumerate() {
+        slots.push(Arc::new(FontSlot {
+            info,
+            source: source.clone(),
+            origin: origin.clone(),
+            index: index as u32,
+            font: OnceLock::new(),
+        }));
+    }
+    count
+}
+
+/// Catalog entry of a single face.
+fn slot_json(slot: &FontSlot) -> serde_json::Value {
+    let info = &slot.info;
+    let style = match info.variant.style {
+        FontStyle::Normal => "normal",
+        FontStyle::Italic => "italic",
+        FontStyle::Oblique => "oblique",
+    };
+
+    // Contiguous code point runs of the coverage.
+    let mut ranges: Vec<[u32; 2]> = Vec::new();
+    let mut codepoints = 0;
+    for c in info.coverage.iter() {
+        codepoints += 1;
+        match ranges.last_mut() {
+            Some(last) if last[1] + 1 == c => last[1] = c,
+            _ => ranges.push([c, c]),
+        }
+    }
+
+    let mut axes = Vec::new();
+    if info.flags.contains(FontFlags::VARIABLE) {
+        if let Some(font) = slot.get() {
+            for axis in font.ttf().variation_axes() {
+                axes.push(json!({
+                    "tag": axis.tag.to_string(),
+                    "min": axis.min_value,
+                    "default": axis.def_value,
+                    "max": axis.max_value,
+                }));
+            }
+        }
+    }
+
+    let mut source = json!({"index": slot.index});
+    match &slot.origin {
+        FontOrigin::Bundled => source["bundled"] = json!(true),
+        FontOrigin::Custom(file, name) => {
+            source["file"] = json!(file);
+            source["path"] = json!(name);
+        }
+    }
+
+    json!({
+        "family": info.family.as_str(),
+        "style": style,
+        "weight": info.variant.weight.to_number(),
+        "stretch": info.variant.stretch.to_ratio().get(),
+        "monospace": info.flags.contains(FontFlags::MONOSPACE),
+        "math": info.flags.contains(FontFlags::MATH),
+        "axes": axes,
+        "coverage": {"codepoints": codepoints, "ranges": ranges},
+        "source": source,
+    })
+}
+
+/// Memory-map a font file read-only.
+fn map(path: &Path) -> std::io::Result<memmap2::Mmap> {
+    let file = File::open(path)?;
+    // Safety: the mapping is read-only. Font files are assumed not to be
+    // truncated or rewritten while a compiler uses them.
+    unsafe { memmap2::Mmap::map(&file) }
+}
+
+/// Name of a font file for warnings; in-memory fonts may be unnamed.
+fn display_name<'a>(file: &FontFile<'a>) -> &'a str {
+    if file.name.is_empty() {
+        "<memory>"
+    } else {
+        file.name
+    }
+}
@@ -1,75 +1,215 @@
 #![allow(private_interfaces)]
 
+mod diag;
+mod document;
+mod eval;
+mod fonts;
+mod package;
+mod timing;
+
+use std::collections::HashMap;
 use std::fmt::Write;
 use std::path::PathBuf;
 use std::slice;
+use std::sync::{Arc, Mutex, RwLock};
+use std::time::Instant;
 
 use chrono::{Datelike, Local};
-use typst::diag::{FileError, FileResult};
-use typst::foundations::{Bytes, Datetime};
+use typst::comemo::Track;
+use typst::diag::{FileError, FileResult, PackageError, SourceDiagnostic};
+use typst::ecow::EcoVec;
+use typst::engine::Sink;
+use typst::foundations::{
+    Array, Binding, Bytes, Content, Datetime, Dict, IntoValue, LocatableSelector, Scope, Str,
+    StyledElem, Styles, Value,
+};
 use typst::layout::PagedDocument;
-use typst::syntax::{FileId, Source, VirtualPath};
+use typst::syntax::package::PackageSpec;
+use typst::syntax::{FileId, Source, Span, SyntaxMode, VirtualPath};
 use typst::text::{Font, FontBook};
 use typst::utils::LazyHash;
 use typst::{Library, LibraryExt, World};
 
-/// Shared, immutable resources owned by a compiler instance.
+/// Shared resources owned by a compiler instance. Everything but the fonts
+/// is immutable after creation.
 pub struct SharedResources {
     library: LazyHash<Library>,
-    book: LazyHash<FontBook>,
-    fonts: Vec<Font>,
+    /// Current font snapshot; compilations clone the Arc when they start.
+    fonts: RwLock<Arc<fonts::FontStore>>,
+    /// Serializes font updates, so concurrent additions are not lost.
+    font_update: Mutex<()>,
     main_id: FileId,
+    /// Problems found while loading fonts, e.g. unreadable files.
+    warnings: Vec<String>,
 }
 
 impl SharedResources {
-    fn new(custom_font_data: &[&[u8]]) -> Self {
-        // Pre-allocate: bundled fonts typically yield ~20 faces,
-        // each custom file usually contains 1-4 faces.
-        let mut fonts = Vec::with_capacity(20 + custom_font_data.len() * 4);
-
-        // Load bundled fonts.
-        for data in typst_assets::fonts() {
-            let bytes = Bytes::new(data);
-            for index in 0.. {
-                match Font::new(bytes.clone(), index) {
-                    Some(font) => fonts.push(font),
-                    None => break,
-                }
-            }
+    /// Resources with the fonts of `base` plus `custom_fonts`. Without
+    /// custom fonts the base store is shared as is.
+    fn new(base: Arc<fonts::FontStore>, custom_fonts: &[fonts::FontFile]) -> Self {
+        let mut warnings = Vec::new();
+        let store = if custom_fonts.is_empty() {
+            base
+        } else {
+            Arc::new(base.with_files(custom_fonts, &mut warnings))
+        };
+        if store.is_empty() {
+            warnings.push("no fonts loaded: text will not render".to_string());
         }
 
-        // Load custom fonts.
-        for data in custom_font_data {
-            let bytes = Bytes::new(data.to_vec());
-            for index in 0.. {
-                match Font::new(bytes.clone(), index) {
-                    Some(font) => fonts.push(font),
-                    None => break,
-                }
-            }
+        SharedResources {
+            library: LazyHash::new(Library::default()),
+            fonts: RwLock::new(store),
+            font_update: Mutex::new(()),
+            main_id: FileId::new(None, VirtualPath::new("/main.typ")),
+            warnings,
         }
+    }
+
+    /// The current font snapshot.
+    fn fonts(&self) -> Arc<fonts::FontStore> {
+        self.fonts.read().unwrap_or_else(|e| e.into_inner()).clone()
+    }
+
+    /// Replace the font snapshot with one derived from the current one.
+    /// The new store is built outside the write lock, so compilations
+    /// starting meanwhile are not blocked.
+    fn update_fonts<T>(&self, f: impl FnOnce(&fonts::FontStore) -> (fonts::FontStore, T)) -> T {
+        let _guard = self.font_update.lock().unwrap_or_else(|e| e.into_inner());
+        let (store, out) = f(&self.fonts());
+        *self.fonts.write().unwrap_or_else(|e| e.into_inner()) = Arc::new(store);
+        out
+    }
 
-        let mut book = FontBook::new();
-        for font in &fonts {
-            book.push(font.info().clone());
+    /// Define global variables from a JSON object, one binding per key.
+    fn define_globals(&mut self, json: &[u8]) -> Result<(), String> {
+        let globals: serde_json::Map<String, serde_json::Value> =
+            serde_json::from_slice(json).map_err(|e| format!("invalid globals: {}", e))?;
+        let scope = self.library.global.scope_mut();
+        for (name, value) in globals {
+            scope.bind(name.into(), Binding::detached(json_to_value(value)));
         }
+        Ok(())
+    }
 
-        SharedResources {
-            library: LazyHash::new(Library::default()),
-            book: LazyHash::new(book),
-            fonts,
-            main_id: FileId::new(None, VirtualPath::new("/main.typ")),
+    /// Evaluate set rules once and apply them as library-wide default styles.
+    fn apply_styles(&mut self, code: &str) -> Result<(), String> {
+        let styles = {
+            let world = SingleSourceWorld::new(
+                self,
+                String::new(),
+                None,
+                PackagePaths::default(),
+                HashMap::new(),
+                "",
+            );
+            let world: &dyn World = &world;
+            let value = typst_eval::eval_string(
+                &typst::ROUTINES,
+                world.track(),
+                Sink::new().track_mut(),
+                code,
+                Span::detached(),
+                SyntaxMode::Code,
+                Scope::new(),
+            )
+            .map_err(|errors| join_messages("style error", &errors))?;
+            match value {
+                Value::None => Styles::new(),
+                Value::Content(content) => match content.to_packed::<StyledElem>() {
+                    Some(styled) if styled.child.is_empty() => styled.styles.clone(),
+                    _ => return Err("style error: styles may only contain set rules".into()),
+                },
+                _ => return Err("style error: styles may only contain set rules".into()),
+            }
+        };
+        self.library.styles.apply(styles);
+        Ok(())
+    }
+}
+
+/// Convert a JSON value into the equivalent Typst value.
+fn json_to_value(value: serde_json::Value) -> Value {
+    match value {
+        serde_json::Value::Null => Value::None,
+        serde_json::Value::Bool(b) => Value::Bool(b),
+        serde_json::Value::Number(n) => match n.as_i64() {
+            Some(i) => Value::Int(i),
+            None => Value::Float(n.as_f64().unwrap_or(f64::NAN)),
+        },
+        serde_json::Value::String(s) => Value::Str(Str::from(s.as_str())),
+        serde_json::Value::Array(items) => {
+            Value::Array(items.into_iter().map(json_to_value).collect::<Array>())
         }
+        serde_json::Value::Object(map) => Value::Dict(
+            map.into_iter()
+                .map(|(k, v)| (Str::from(k.as_str()), json_to_value(v)))
+                .collect::<Dict>(),
+        ),
+    }
+}
+
+/// Join diagnostic messages into one newline-terminated line per diagnostic.
+fn join_messages(prefix: &str, errors: &EcoVec<SourceDiagnostic>) -> String {
+    let mut msg = String::with_capacity(errors.len() * 64);
+    for err in errors.iter() {
+        let _ = write!(msg, "{}: {}\n", prefix, err.message);
+    }
+    msg
+}
+
+/// Where packages are looked up.
+#[derive(Default)]
+struct PackagePaths {
+    /// Directories holding {namespace}/{name}/{version}, searched in order.
+    dirs: Vec<PathBuf>,
+    /// Directories holding {name}/{version} for one namespace; a mapped
+    /// namespace is not searched in `dirs`.
+    namespaces: HashMap<String, PathBuf>,
+}
+
+impl PackagePaths {
+    /// The directory of a package and its canonical form, from the first
+    /// search location that has it.
+    fn find(&self, pkg: &PackageSpec) -> Option<(PathBuf, PathBuf)> {
+        let version = pkg.version.to_string();
+        let candidates: Vec<PathBuf> = match self.namespaces.get(pkg.namespace.as_str()) {
+            Some(dir) => vec![dir.join(pkg.name.as_str()).join(&version)],
+            None => self
+                .dirs
+                .iter()
+                .map(|dir| {
+                    dir.join(pkg.namespace.as_str())
+                        .join(pkg.name.as_str())
+                        .join(&version)
+                })
+                .collect(),
+        };
+        candidates
+            .into_iter()
+            .find_map(|dir| Some((dir.clone(), dir.canonicalize().ok()?)))
     }
 }
 
 /// A minimal World that borrows shared resources and owns a single source.
 struct SingleSourceWorld<'a> {
     shared: &'a SharedResources,
+    /// Fonts as of the start of the compilation.
+    fonts: Arc<fonts::FontStore>,
     source: Source,
     root: Option<PathBuf>,
     canonical_root: Option<PathBuf>,
-    package_cache: Option<PathBuf>,
+    packages: PackagePaths,
+    /// In-memory files supplied by the caller; checked before the disk.
+    files: HashMap<FileId, Bytes>,
+    /// Packages imported but not found in any package directory.
+    missing_packages: Mutex<Vec<PackageSpec>>,
+    /// Sources loaded besides the main source, kept to resolve spans after
+    /// compilation.
+    sources: Mutex<HashMap<FileId, Source>>,
+    /// Byte length and line count of the preamble prepended to the main source.
+    preamble_len: usize,
+    preamble_lines: usize,
 }
 
 impl<'a> SingleSourceWorld<'a> {
@@ -77,36 +217,66 @@ impl<'a> SingleSourceWorld<'a> {
         shared: &'a SharedResources,
         source_text: String,
         root: Option<PathBuf>,
-        package_cache: Option<PathBuf>,
+        packages: PackagePaths,
+        files: HashMap<FileId, Bytes>,
+        preamble: &str,
     ) -> Self {
         // Pre-compute canonical root once to avoid repeated canonicalize() in resolve_path.
         let canonical_root = root.as_ref().and_then(|r| r.canonicalize().ok());
+
+        // The preamble goes on its own lines ahead of the source; diagnostics
+        // are shifted back by preamble_lines so they point into the source.
+        let (text, preamble_len, preamble_lines) = if preamble.is_empty() {
+            (source_text, 0, 0)
+        } else {
+            let mut text = String::with_capacity(preamble.len() + 1 + source_text.len());
+            text.push_str(preamble);
+            text.push('\n');
+            text.push_str(&source_text);
+            (text, preamble.len() + 1, preamble.matches('\n').count() + 1)
+        };
+
         SingleSourceWorld {
             shared,
-            source: Source::new(shared.main_id, source_text),
+            fonts: shared.fonts(),
+            source: Source::new(shared.main_id, text),
             root,
             canonical_root,
-            package_cache,
+            packages,
+            files,
+            missing_packages: Mutex::new(Vec::new()),
+            sources: Mutex::new(HashMap::new()),
+            preamble_len,
+            preamble_lines,
         }
     }
 
+    /// The packages imported but not found, as "@namespace/name:version".
+    fn missing_packages(&self) -> Vec<String> {
+        let missing = self
+            .missing_packages
+            .lock()
+            .unwrap_or_else(|e| e.into_inner());
+        missing.iter().map(|spec| spec.to_string()).collect()
+    }
+
     /// Resolve a FileId to an absolute path on disk, with path traversal protection.
     fn resolve_path(&self, id: FileId) -> FileResult<PathBuf> {
         let vpath = id.vpath().as_rootless_path();
 
         let (base, canonical_base) = if let Some(pkg) = id.package() {
-            // Package file: {cache}/{namespace}/{name}/{version}/
-            let cache = self
-                .package_cache
-                .as_ref()
-                .ok_or_else(|| FileError::NotFound(vpath.into()))?;
-            let b = cache
-                .join(pkg.namespace.as_str())
-                .join(pkg.name.as_str())
-                .join(pkg.version.to_string());
-            let cb = b
-                .canonicalize()
-                .map_err(|_| FileError::NotFound(vpath.into()))?;
+            // Package file: {dir}/{namespace}/{name}/{version}/ in the first
+            // package directory that has it.
+            let Some((b, cb)) = self.packages.find(pkg) else {
+                let mut missing = self
+                    .missing_packages
+                    .lock()
+                    .unwrap_or_else(|e| e.into_inner());
+                if !missing.contains(pkg) {
+                    missing.push(pkg.clone());
+                }
+                return Err(FileError::Package(PackageError::NotFound(pkg.clone())));
+            };
             (b, cb)
         } else {
             // Local file: resolve relative to root.
@@ -145,7 +315,7 @@ impl World for SingleSourceWorld<'_> {
     }
 
     fn book(&self) -> &LazyHash<FontBook> {
-        &self.shared.book
+        &self.fonts.book
     }
 
     fn main(&self) -> FileId {
@@ -156,13 +326,28 @@ impl World for SingleSourceWorld<'_> {
         if id == self.source.id() {
             return Ok(self.source.clone());
         }
-        let path = self.resolve_path(id)?;
-        let text = std::fs::read_to_string(&path)
-            .map_err(|_| FileError::NotFound(id.vpath().as_rootless_path().into()))?;
-        Ok(Source::new(id, text))
+        if let Some(source) = self.sources.lock().unwrap().get(&id) {
+            return Ok(source.clone());
+        }
+        let text = match self.files.get(&id) {
+            Some(data) => std::str::from_utf8(data)
+                .map_err(|_| FileError::InvalidUtf8)?
+                .to_string(),
+            None => {
+                let path = self.resolve_path(id)?;
+                std::fs::read_to_string(&path)
+                    .map_err(|_| FileError::NotFound(id.vpath().as_rootless_path().into()))?
+            }
+        };
+        let source = Source::new(id, text);
+        self.sources.lock().unwrap().insert(id, source.clone());
+        Ok(source)
     }
 
     fn file(&self, id: FileId) -> FileResult<Bytes> {
+        if let Some(data) = self.files.get(&id) {
+            return Ok(data.clone());
+        }
         let path = self.resolve_path(id)?;
         let data = std::fs::read(&path)
             .map_err(|_| FileError::NotFound(id.vpath().as_rootless_path().into()))?;
@@ -170,7 +355,7 @@ impl World for SingleSourceWorld<'_> {
     }
 
     fn font(&self, index: usize) -> Option<Font> {
-        self.shared.fonts.get(index).cloned()
+        self.fonts.font(index)
     }
 
     fn today(&self, offset: Option<i64>) -> Option<Datetime> {
@@ -204,51 +389,380 @@ pub struct TypstResult {
     pub len: usize,
     /// 0 = success, 1 = error.
     pub error: i32,
+    /// On successful compilation, the document owning `data`; null otherwise.
+    pub doc: *mut document::TypstDocument,
+}
+
+/// A borrowed byte string passed across the FFI boundary.
+#[repr(C)]
+pub struct TypstSlice {
+    pub ptr: *const u8,
+    pub len: usize,
+}
+
+impl TypstSlice {
+    /// View the slice as bytes. Null or empty slices yield an empty slice.
+    ///
+    /// # Safety
+    /// `ptr` must point to `len` valid bytes for the lifetime `'a`.
+    unsafe fn as_bytes<'a>(&self) -> &'a [u8] {
+        if self.ptr.is_null() || self.len == 0 {
+            &[]
+        } else {
+            unsafe { slice::from_raw_parts(self.ptr, self.len) }
+        }
+    }
+
+    /// View the slice as UTF-8. Null, empty, or invalid slices yield `None`.
+    ///
+    /// # Safety
+    /// Same as [`TypstSlice::as_bytes`].
+    unsafe fn as_str<'a>(&self) -> Option<&'a str> {
+        let bytes = unsafe { self.as_bytes() };
+        if bytes.is_empty() {
+            return None;
+        }
+        std::str::from_utf8(bytes).ok()
+    }
+}
+
+/// An in-memory file made visible to the compilation at `path`.
+#[repr(C)]
+pub struct TypstFile {
+    pub path: TypstSlice,
+    pub data: TypstSlice,
+}
+
+/// A directory holding the packages of one namespace as {name}/{version}.
+#[repr(C)]
+pub struct TypstPackageNamespace {
+    pub namespace: TypstSlice,
+    pub dir: TypstSlice,
+}
+
+/// Per-compilation options. All fields are optional (NULL/0 = unset).
+#[repr(C)]
+pub struct TypstCompileOptions {
+    /// Root directory for local file resolution.
+    pub root: TypstSlice,
+    /// Package directories holding {namespace}/{name}/{version}, searched
+    /// in order.
+    pub package_paths: *const TypstSlice,
+    pub package_path_count: usize,
+    /// Per-namespace package directories, used instead of `package_paths`
+    /// for their namespace.
+    pub package_namespaces: *const TypstPackageNamespace,
+    pub package_namespace_count: usize,
+    /// In-memory files, resolved before the root directory.
+    pub files: *const TypstFile,
+    pub file_count: usize,
+    /// Typst markup prepended to the source, e.g. set rules.
+    pub preamble: TypstSlice,
+    /// Comma-separated PDF standards, named as in the typst CLI (e.g. "a-3b").
+    pub pdf_standards: TypstSlice,
+    /// JSON object overriding document metadata (title, authors, ...).
+    pub metadata: TypstSlice,
+    /// Selector to query the laid-out document for, in `typst query` syntax.
+    pub query: TypstSlice,
+    /// Nonzero to skip PDF export; the result then carries no PDF bytes.
+    pub skip_pdf: i32,
+    /// Nonzero to record timing spans as a Chrome trace.
+    pub trace: i32,
+    /// Nonzero to keep the laid-out document for text, link and font
+    /// inspection.
+    pub keep_layout: i32,
+}
+
+impl TypstCompileOptions {
+    /// Collect the package directories.
+    ///
+    /// # Safety
+    /// `package_paths` and `package_namespaces` must each be null or point to
+    /// their count of entries with valid slices.
+    unsafe fn package_paths(&self) -> PackagePaths {
+        let mut packages = PackagePaths::default();
+        if !self.package_paths.is_null() && self.package_path_count > 0 {
+            let dirs =
+                unsafe { slice::from_raw_parts(self.package_paths, self.package_path_count) };
+            packages.dirs = dirs
+                .iter()
+                .filter_map(|dir| unsafe { dir.as_str() })
+                .map(PathBuf::from)
+                .collect();
+        }
+        if !self.package_namespaces.is_null() && self.package_namespace_count > 0 {
+            let entries = unsafe {
+                slice::from_raw_parts(self.package_namespaces, self.package_namespace_count)
+            };
+            for entry in entries {
+                if let (Some(namespace), Some(dir)) =
+                    (unsafe { entry.namespace.as_str() }, unsafe {
+                        entry.dir.as_str()
+                    })
+                {
+                    packages
+                        .namespaces
+                        .insert(namespace.to_string(), PathBuf::from(dir));
+                }
+            }
+        }
+        packages
+    }
+
+    /// Copy the in-memory files into a map keyed by file id.
+    ///
+    /// # Safety
+    /// `files` must be null or point to `file_count` entries with valid slices.
+    unsafe fn in_memory_files(&self) -> HashMap<FileId, Bytes> {
+        let mut files = HashMap::new();
+        if self.files.is_null() || self.file_count == 0 {
+            return files;
+        }
+        let entries = unsafe { slice::from_raw_parts(self.files, self.file_count) };
+        for entry in entries {
+            let Some(path) = (unsafe { entry.path.as_str() }) else {
+                continue;
+            };
+            let id = FileId::new(None, VirtualPath::new(path));
+            let data = Bytes::new(unsafe { entry.data.as_bytes() }.to_vec());
+            files.insert(id, data);
+        }
+        files
+    }
+}
+
+/// Options for creating a compiler instance. All fields are optional (NULL/0 = unset).
+#[repr(C)]
+pub struct TypstWorldOptions {
+    /// Custom font files (TTF/OTF/TTC/OTC), loaded on top of the bundled
+    /// fonts. Files without data are read from disk at their path.
+    pub fonts: *const TypstFile,
+    pub font_count: usize,
+    /// JSON object whose keys are defined as global Typst variables.
+    pub globals: TypstSlice,
+    /// Typst set rules (code syntax) applied as default styles.
+    pub styles: TypstSlice,
+    /// Nonzero to leave out the bundled fonts; only custom fonts are loaded.
+    pub no_bundled_fonts: i32,
+    /// Fonts shared with other instances, used instead of the bundled fonts;
+    /// `fonts` are added on top for this instance only.
+    pub font_set: *const TypstFontSet,
+}
+
+/// Fonts loaded once and shared by several compiler instances. Each instance
+/// holds a reference, so the set may be freed while they are still in use.
+pub struct TypstFontSet {
+    store: Arc<fonts::FontStore>,
+    /// Problems found while loading the fonts, e.g. unreadable files.
+    warnings: Vec<String>,
+}
+
+/// Load a font set to share between compiler instances.
+///
+/// # Safety
+/// `fonts` must point to `count` valid entries, or be null if `count` is 0.
+///
+/// Returns a heap-allocated handle. Free with `typst_font_set_free`.
+#[no_mangle]
+pub unsafe extern "C" fn typst_font_set_new(
+    fonts: *const TypstFile,
+    count: usize,
+    no_bundled_fonts: i32,
+) -> *mut TypstFontSet {
+    let files = unsafe { font_files(fonts, count) };
+    let mut warnings = Vec::new();
+    let store = fonts::FontStore::new(no_bundled_fonts == 0).with_files(&files, &mut warnings);
+    Box::into_raw(Box::new(TypstFontSet {
+        store: Arc::new(store),
+        warnings,
+    }))
+}
+
+/// Warnings from loading a font set, e.g. skipped font files, as a JSON
+/// array of strings.
+///
+/// # Safety
+/// `set` must be a valid pointer from `typst_font_set_new`.
+/// Free the result with `typst_free_result`.
+#[no_mangle]
+pub unsafe extern "C" fn typst_font_set_warnings(set: *const TypstFontSet) -> TypstResult {
+    let set = unsafe { &*set };
+    make_data(serde_json::to_vec(&set.warnings).unwrap_or_default())
+}
+
+/// Release a font set. Compiler instances created with it keep their fonts.
+///
+/// # Safety
+/// `set` must be a pointer from `typst_font_set_new`, or null.
+#[no_mangle]
+pub unsafe extern "C" fn typst_font_set_free(set: *mut TypstFontSet) {
+    if !set.is_null() {
+        let _ = unsafe { Box::from_raw(set) };
+    }
 }
 
-/// Create a new compiler instance with optional custom fonts.
+/// Create a new compiler instance.
 ///
 /// # Safety
-/// Each `font_ptrs[i]` must point to `font_lens[i]` valid bytes.
-/// Returns a heap-allocated handle. Free with `typst_world_free`.
+/// - `options` may be null; otherwise every slice in it must be valid for the call.
+/// - `error` may be null; otherwise, on failure, it receives a message to be
+///   freed with `typst_free_result`.
+///
+/// Returns a heap-allocated handle, or null on failure. Free with `typst_world_free`.
 #[no_mangle]
 pub unsafe extern "C" fn typst_world_new(
-    font_ptrs: *const *const u8,
-    font_lens: *const usize,
-    font_count: usize,
+    options: *const TypstWorldOptions,
+    error: *mut TypstResult,
 ) -> *mut TypstWorld {
-    let custom: Vec<&[u8]> = if font_count > 0 && !font_ptrs.is_null() && !font_lens.is_null() {
-        let ptrs = unsafe { slice::from_raw_parts(font_ptrs, font_count) };
-        let lens = unsafe { slice::from_raw_parts(font_lens, font_count) };
-        ptrs.iter()
-            .zip(lens.iter())
-            .map(|(&ptr, &len)| unsafe { slice::from_raw_parts(ptr, len) })
-            .collect()
-    } else {
-        Vec::new()
+    let options = unsafe { options.as_ref() };
+
+    let custom = match options {
+        Some(o) => unsafe { font_files(o.fonts, o.font_count) },
+        None => Vec::new(),
     };
 
-    let resources = SharedResources::new(&custom);
+    let base = match options.and_then(|o| unsafe { o.font_set.as_ref() }) {
+        Some(set) => set.store.clone(),
+        None => {
+            let bundled_fonts = options.is_none_or(|o| o.no_bundled_fonts == 0);
+            Arc::new(fonts::FontStore::new(bundled_fonts))
+        }
+    };
+    let mut resources = SharedResources::new(base, &custom);
+
+    if let Some(o) = options {
+        let mut configured = Ok(());
+        if let Some(globals) = unsafe { o.globals.as_str() } {
+            configured = resources.define_globals(globals.as_bytes());
+        }
+        if let (Ok(()), Some(styles)) = (&configured, unsafe { o.styles.as_str() }) {
+            configured = resources.apply_styles(styles);
+        }
+        if let Err(msg) = configured {
+            if let Some(error) = unsafe { error.as_mut() } {
+                *error = make_error(msg);
+            }
+            return std::ptr::null_mut();
+        }
+    }
+
     Box::into_raw(Box::new(resources))
 }
 
+/// Warnings from creating a compiler instance, e.g. skipped font files, as a
+/// JSON array of strings.
+///
+/// # Safety
+/// `world` must be a valid pointer from `typst_world_new`.
+/// Free the result with `typst_free_result`.
+#[no_mangle]
+pub unsafe extern "C" fn typst_world_warnings(world: *const TypstWorld) -> TypstResult {
+    let shared = unsafe { &*world };
+    make_data(serde_json::to_vec(&shared.warnings).unwrap_or_default())
+}
+
+/// The fonts of a compiler instance, with metadata, coverage and origin, as
+/// a JSON array in book order.
+///
+/// # Safety
+/// `world` must be a valid pointer from `typst_world_new`.
+/// Free the result with `typst_free_result`.
+#[no_mangle]
+pub unsafe extern "C" fn typst_world_fonts(world: *const TypstWorld) -> TypstResult {
+    let shared = unsafe { &*world };
+    make_data(shared.fonts().catalog_json().into_bytes())
+}
+
+/// Whether a compiler instance has a font of the given family
+/// (case-insensitive), as `#set text(font: ...)` would find it.
+///
+/// # Safety
+/// - `world` must be a valid pointer from `typst_world_new`.
+/// - `family` must be a valid UTF-8 slice.
+#[no_mangle]
+pub unsafe extern "C" fn typst_world_has_font(world: *const TypstWorld, family: TypstSlice) -> i32 {
+    let shared = unsafe { &*world };
+    let family = unsafe { family.as_str() }.unwrap_or("");
+    shared.fonts().contains_family(family) as i32
+}
+
+/// Add font files to a compiler instance. Compilations already running keep
+/// the fonts they started with. Returns the warnings for skipped files as a
+/// JSON array of strings.
+///
+/// # Safety
+/// - `world` must be a valid pointer from `typst_world_new`.
+/// - `fonts` must point to `count` valid entries, or be null if `count` is 0.
+/// - Free the result with `typst_free_result`.
+#[no_mangle]
+pub unsafe extern "C" fn typst_world_add_fonts(
+    world: *const TypstWorld,
+    fonts: *const TypstFile,
+    count: usize,
+) -> TypstResult {
+    let shared = unsafe { &*world };
+    let files = unsafe { font_files(fonts, count) };
+    let mut warnings = Vec::new();
+    shared.update_fonts(|store| (store.with_files(&files, &mut warnings), ()));
+    make_data(serde_json::to_vec(&warnings).unwrap_or_default())
+}
+
+/// Remove every face of the given families (case-insensitive) from a
+/// compiler instance. Compilations already running keep the fonts they
+/// started with. Returns the number of faces removed.
+///
+/// # Safety
+/// - `world` must be a valid pointer from `typst_world_new`.
+/// - `families` must point to `count` valid UTF-8 slices, or be null if
+///   `count` is 0.
+#[no_mangle]
+pub unsafe extern "C" fn typst_world_remove_fonts(
+    world: *const TypstWorld,
+    families: *const TypstSlice,
+    count: usize,
+) -> usize {
+    let shared = unsafe { &*world };
+    if families.is_null() || count == 0 {
+        return 0;
+    }
+    let families: Vec<&str> = unsafe { slice::from_raw_parts(families, count) }
+        .iter()
+        .filter_map(|f| unsafe { f.as_str() })
+        .collect();
+    shared.update_fonts(|store| store.without_families(&families))
+}
+
+/// Font files from a C array; files without data are read from disk at
+/// their path.
+///
+/// # Safety
+/// `files` must point to `count` valid entries, or be null.
+unsafe fn font_files<'a>(files: *const TypstFile, count: usize) -> Vec<fonts::FontFile<'a>> {
+    if files.is_null() || count == 0 {
+        return Vec::new();
+    }
+    unsafe { slice::from_raw_parts(files, count) }
+        .iter()
+        .map(|f| fonts::FontFile {
+            name: unsafe { f.path.as_str() }.unwrap_or(""),
+            data: Some(unsafe { f.data.as_bytes() }).filter(|d| !d.is_empty()),
+        })
+        .collect()
+}
+
 /// Compile a Typst source string to PDF using the given compiler instance.
 ///
 /// # Safety
 /// - `world` must be a valid pointer from `typst_world_new`.
 /// - `source_ptr` must point to `source_len` valid UTF-8 bytes.
-/// - `root_ptr`/`root_len`: optional root directory for local file resolution (NULL/0 = disabled).
-/// - `pkg_ptr`/`pkg_len`: optional package cache directory (NULL/0 = disabled).
+/// - `options` may be null; otherwise every slice in it must be valid for the call.
 /// - Free the result with `typst_free_result`.
 #[no_mangle]
 pub unsafe extern "C" fn typst_world_compile(
     world: *const TypstWorld,
     source_ptr: *const u8,
     source_len: usize,
-    root_ptr: *const u8,
-    root_len: usize,
-    pkg_ptr: *const u8,
-    pkg_len: usize,
+    options: *const TypstCompileOptions,
 ) -> TypstResult {
     let shared = unsafe { &*world };
 
@@ -256,76 +770,193 @@ pub unsafe extern "C" fn typst_world_compile(
     let source_text = match std::str::from_utf8(source_bytes) {
         Ok(s) => s.to_string(),
         Err(e) => {
-            return make_error(format!("invalid UTF-8 input: {}", e));
+            return diag::error(format!("invalid UTF-8 input: {}\n", e), Vec::new());
         }
     };
 
-    let root = if !root_ptr.is_null() && root_len > 0 {
-        let bytes = unsafe { slice::from_raw_parts(root_ptr, root_len) };
-        match std::str::from_utf8(bytes) {
-            Ok(s) => Some(PathBuf::from(s)),
-            Err(_) => None,
+    let mut root = None;
+    let mut packages = PackagePaths::default();
+    let mut files = HashMap::new();
+    let mut preamble = "";
+    let mut standards = None;
+    let mut metadata = None;
+    let mut selector = None;
+    let mut skip_pdf = false;
+    let mut trace = false;
+    let mut keep_layout = false;
+    if let Some(options) = unsafe { options.as_ref() } {
+        metadata = unsafe { options.metadata.as_str() };
+        selector = unsafe { options.query.as_str() };
+        skip_pdf = options.skip_pdf != 0;
+        trace = options.trace != 0;
+        keep_layout = options.keep_layout != 0;
+        preamble = unsafe { options.preamble.as_str() }.unwrap_or("");
+        standards = unsafe { options.pdf_standards.as_str() };
+        root = unsafe { options.root.as_str() }.map(PathBuf::from);
+        packages = unsafe { options.package_paths() };
+        files = unsafe { options.in_memory_files() };
+    }
+
+    if trace {
+        timing::start_trace();
+    }
+    let mut extras = document::CompileExtras::default();
+
+    // Constructing the world parses the main source; the rest of it is
+    // bookkeeping, so this is what the parse phase measures.
+    let start = Instant::now();
+    let world = SingleSourceWorld::new(shared, source_text, root, packages, files, preamble);
+    extras.timings.parse = start.elapsed();
+
+    let start = Instant::now();
+    timing::eval_main(&world);
+    extras.timings.eval = start.elapsed();
+
+    let start = Instant::now();
+    let result = typst::compile::<PagedDocument>(&world);
+    extras.timings.layout = start.elapsed();
+
+    let export = || -> Result<(Vec<u8>, PagedDocument), serde_json::Value> {
+        let mut document = result.output.map_err(|errors| {
+            diag::failure_payload(&world, &result.warnings, &errors, "compile error")
+        })?;
+        if let Some(metadata) = metadata {
+            document::apply_metadata(&mut document.info, metadata).map_err(|msg| {
+                diag::error_payload(format!("metadata error: {}\n", msg), Vec::new())
+            })?;
+        }
+        if let Some(selector) = selector {
+            let query = run_query(&world, &document, selector);
+            extras.query = Some(query.map_err(|msg| diag::error_payload(msg, Vec::new()))?);
+        }
+        if skip_pdf {
+            return Ok((Vec::new(), document));
         }
-    } else {
-        None
+
+        let options = pdf_options(standards).map_err(|msg| {
+            diag::error_payload(format!("pdf export error: {}\n", msg), Vec::new())
+        })?;
+        let start = Instant::now();
+        let pdf = typst_pdf::pdf(&document, &options).map_err(|errors| {
+            diag::failure_payload(&world, &result.warnings, &errors, "pdf export error")
+        })?;
+        extras.timings.pdf = start.elapsed();
+        Ok((pdf, document))
     };
+    let exported = export();
+
+    let trace = trace.then(|| timing::finish_trace(&world));
+    match exported {
+        Ok((pdf, document)) => {
+            extras.trace = trace;
+            let sources = keep_layout.then(|| world.source_map());
+            document::TypstDocument::into_result(pdf, document, sources, extras)
+        }
+        Err(mut payload) => {
+            // The trace of a failed compile still shows where the time went.
+            if let Some(trace) = trace {
+                payload["trace"] = serde_json::json!(String::from_utf8_lossy(&trace));
+            }
+            make_error(payload.to_string())
+        }
+    }
+}
 
-    let package_cache = if !pkg_ptr.is_null() && pkg_len > 0 {
-        let bytes = unsafe { slice::from_raw_parts(pkg_ptr, pkg_len) };
-        match std::str::from_utf8(bytes) {
-            Ok(s) => Some(PathBuf::from(s)),
-            Err(_) => None,
+/// Evaluate Typst code (code syntax, like `typst eval`) and return its value.
+///
+/// # Safety
+/// - `world` must be a valid pointer from `typst_world_new`.
+/// - `code_ptr` must point to `code_len` valid UTF-8 bytes.
+/// - `options` may be null; only root, the package directories and files
+///   are used.
+/// - Free the result with `typst_free_result`.
+#[no_mangle]
+pub unsafe extern "C" fn typst_world_eval(
+    world: *const TypstWorld,
+    code_ptr: *const u8,
+    code_len: usize,
+    options: *const TypstCompileOptions,
+) -> TypstResult {
+    let shared = unsafe { &*world };
+
+    let code_bytes = unsafe { slice::from_raw_parts(code_ptr, code_len) };
+    let code = match std::str::from_utf8(code_bytes) {
+        Ok(s) => s,
+        Err(e) => {
+            return diag::error(format!("invalid UTF-8 input: {}\n", e), Vec::new());
         }
-    } else {
-        None
     };
 
-    let world = SingleSourceWorld::new(shared, source_text, root, package_cache);
-    let result = typst::compile::<PagedDocument>(&world);
+    let mut root = None;
+    let mut packages = PackagePaths::default();
+    let mut files = HashMap::new();
+    if let Some(options) = unsafe { options.as_ref() } {
+        root = unsafe { options.root.as_str() }.map(PathBuf::from);
+        packages = unsafe { options.package_paths() };
+        files = unsafe { options.in_memory_files() };
+    }
 
-    match result.output {
-        Ok(document) => {
-            let options = typst_pdf::PdfOptions {
-                tagged: false,
-                ..typst_pdf::PdfOptions::default()
-            };
-            match typst_pdf::pdf(&document, &options) {
-                Ok(pdf_bytes) => {
-                    // Leak the PDF bytes into C-owned memory; Go will free via typst_free_result.
-                    let mut boxed = pdf_bytes.into_boxed_slice();
-                    let ptr = boxed.as_mut_ptr();
-                    let len = boxed.len();
-                    std::mem::forget(boxed);
-                    TypstResult {
-                        data: ptr,
-                        len,
-                        error: 0,
-                    }
-                }
-                Err(errors) => {
-                    let mut msg =
-                        String::with_capacity((result.warnings.len() + errors.len()) * 64);
-                    for w in result.warnings.iter() {
-                        let _ = write!(msg, "warning: {}\n", w.message);
-                    }
-                    for err in errors.iter() {
-                        let _ = write!(msg, "pdf export error: {}\n", err.message);
-                    }
-                    make_error(msg)
-                }
-            }
-        }
-        Err(errors) => {
-            let mut msg = String::with_capacity((result.warnings.len() + errors.len()) * 64);
-            for w in result.warnings.iter() {
-                let _ = write!(msg, "warning: {}\n", w.message);
-            }
-            for err in errors.iter() {
-                let _ = write!(msg, "compile error: {}\n", err.message);
-            }
-            make_error(msg)
+    let world = SingleSourceWorld::new(shared, code.to_string(), root, packages, files, "");
+    eval::eval(&world, code)
+}
+
+/// Run a `typst query` style selector against a laid-out document and return
+/// the matching elements as a JSON array.
+fn run_query(
+    world: &SingleSourceWorld,
+    document: &PagedDocument,
+    selector: &str,
+) -> Result<String, String> {
+    let world: &dyn World = world;
+    let selector = typst_eval::eval_string(
+        &typst::ROUTINES,
+        world.track(),
+        Sink::new().track_mut(),
+        selector,
+        Span::detached(),
+        SyntaxMode::Code,
+        Scope::new(),
+    )
+    .map_err(|errors| join_messages("query error", &errors))?
+    .cast::<LocatableSelector>()
+    .map_err(|e| format!("query error: {}\n", e.message()))?;
+
+    let elements: Vec<Value> = document
+        .introspector
+        .query(&selector.0)
+        .into_iter()
+        .map(Content::into_value)
+        .collect();
+    serde_json::to_string(&elements).map_err(|e| format!("query error: {}\n", e))
+}
+
+/// Build PDF export options for a comma-separated list of standards.
+fn pdf_options(standards: Option<&str>) -> Result<typst_pdf::PdfOptions<'static>, String> {
+    let mut options = typst_pdf::PdfOptions {
+        tagged: false,
+        ..typst_pdf::PdfOptions::default()
+    };
+    let Some(standards) = standards else {
+        return Ok(options);
+    };
+
+    let mut list = Vec::new();
+    for name in standards
+        .split(',')
+        .map(str::trim)
+        .filter(|n| !n.is_empty())
+    {
+        // PdfStandard deserializes from the CLI names ("1.7", "a-3b", "ua-1").
+        let standard: typst_pdf::PdfStandard = serde_json::from_value(serde_json::json!(name))
+            .map_err(|_| format!("unknown PDF standard: {}", name))?;
+        list.push(standard);
+        // Accessible conformance levels require a tagged PDF.
+        if matches!(name, "a-1a" | "a-2a" | "a-3a" | "ua-1") {
+            options.tagged = true;
         }
     }
+    options.standards = typst_pdf::PdfStandards::new(&list).map_err(|e| e.to_string())?;
+    Ok(options)
 }
 
 /// Free a compiler instance.
@@ -342,7 +973,8 @@ pub unsafe extern "C" fn typst_world_free(world: *mut TypstWorld) {
 /// Free memory allocated by `typst_world_compile`.
 ///
 /// # Safety
-/// `data` and `len` must come from a previous `TypstResult`.
+/// `data` and `len` must come from a previous `TypstResult` without a `doc`
+/// (PDF bytes are freed with `typst_document_free`).
 #[no_mangle]
 pub unsafe extern "C" fn typst_free_result(data: *mut u8, len: usize) {
     if !data.is_null() && len > 0 {
@@ -354,13 +986,23 @@ pub unsafe extern "C" fn typst_free_result(data: *mut u8, len: usize) {
 /// Convert an error message into a TypstResult with error flag set.
 /// The message bytes are leaked into C-owned memory for Go to read and free.
 fn make_error(msg: String) -> TypstResult {
-    let mut bytes = msg.into_bytes().into_boxed_slice();
+    TypstResult {
+        error: 1,
+        ..make_data(msg.into_bytes())
+    }
+}
+
+/// Wrap bytes into a successful TypstResult.
+/// The bytes are leaked into C-owned memory for Go to read and free.
+fn make_data(data: Vec<u8>) -> TypstResult {
+    let mut bytes = data.into_boxed_slice();
     let ptr = bytes.as_mut_ptr();
     let len = bytes.len();
     std::mem::forget(bytes);
     TypstResult {
         data: ptr,
         len,
-        error: 1,
+        error: 0,
+        doc: std::ptr::null_mut(),
     }
 }
@@ -0,0 +1,110 @@
+//! Package tooling that needs no compilation: collecting the imports of a
+//! source file and reading package manifests.
+
+use std::str::FromStr;
+
+use serde_json::json;
+use typst::syntax::package::{PackageManifest, PackageSpec};
+use typst::syntax::{ast, parse, SyntaxNode};
+
+use crate::{make_data, make_error, TypstResult, TypstSlice};
+
+/// Collect the string paths of the import and include expressions below
+/// `node`, in source order. Paths computed at run time are not seen.
+fn collect_imports(node: &SyntaxNode, out: &mut Vec<String>) {
+    let source = match node.cast::<ast::ModuleImport>() {
+        Some(import) => Some(import.source()),
+        None => node
+            .cast::<ast::ModuleInclude>()
+            .map(|include| include.source()),
+    };
+    if let Some(ast::Expr::Str(path)) = source {
+        out.push(path.get().to_string());
+    }
+    for child in node.children() {
+        collect_imports(child, out);
+    }
+}
+
+// ---------------------------------------------------------------------------
+// FFI
+// ---------------------------------------------------------------------------
+
+/// Parse a Typst source (markup) and return the string paths its import and
+/// include expressions name, including those in branches that may not run,
+/// as a JSON object: `packages` holds the package specs, `files` the other
+/// paths, each in source order.
+///
+/// # Safety
+/// - `source` must be a valid slice for the call.
+/// - Free the result with `typst_free_result`.
+#[no_mangle]
+pub unsafe extern "C" fn typst_source_imports(source: TypstSlice) -> TypstResult {
+    let text = match std::str::from_utf8(unsafe { source.as_bytes() }) {
+        Ok(text) => text,
+        Err(e) => return make_error(format!("invalid UTF-8 input: {}", e)),
+    };
+    let mut paths = Vec::new();
+    collect_imports(&parse(text), &mut paths);
+
+    let (packages, files): (Vec<_>, Vec<_>) = paths.into_iter().partition(|p| p.starts_with('@'));
+    let packages: Vec<_> = packages
+        .iter()
+        .filter_map(|p| PackageSpec::from_str(p).ok())
+        .map(|spec| spec.to_string())
+        .collect();
+    make_data(
+        json!({
+            "packages": packages,
+            "files": files,
+        })
+        .to_string()
+        .into_bytes(),
+    )
+}
+
+/// Parse a typst.toml the way importing the package does and return its
+/// `[package]` table as JSON. If `spec` names a package, the manifest is also
+/// checked against it as an import of that package would, with the error in
+/// `problem`: a different name or version, or a newer compiler required.
+/// Sets error if the manifest does not parse.
+///
+/// # Safety
+/// - `manifest` and `spec` must be valid slices for the call; `spec` may be
+///   empty.
+/// - Free the result with `typst_free_result`.
+#[no_mangle]
+pub unsafe extern "C" fn typst_package_manifest(
+    manifest: TypstSlice,
+    spec: TypstSlice,
+) -> TypstResult {
+    let text = match std::str::from_utf8(unsafe { manifest.as_bytes() }) {
+        Ok(text) => text,
+        Err(e) => return make_error(format!("invalid UTF-8: {}", e)),
+    };
+    let manifest: PackageManifest = match toml::from_str(text) {
+        Ok(manifest) => manifest,
+        Err(e) => return make_error(e.message().to_string()),
+    };
+    let problem = unsafe { spec.as_str() }
+        .and_then(|spec| PackageSpec::from_str(spec).ok())
+        .and_then(|spec| manifest.validate(&spec).err())
+        .map(|msg| msg.to_string());
+
+    let info = &manifest.package;
+    make_data(
+        json!({
+            "name": info.name.as_str(),
+            "version": info.version.to_string(),
+            "entrypoint": info.entrypoint.as_str(),
+            "authors": info.authors.iter().map(|a| a.as_str()).collect::<Vec<_>>(),
+            "license": info.license.as_deref(),
+            "description": info.description.as_deref(),
+            "compiler": info.compiler.as_ref().map(|bound| bound.to_string()),
+            "exclude": info.exclude.iter().map(|e| e.as_str()).collect::<Vec<_>>(),
+            "problem": problem,
+        })
+        .to_string()
+        .into_bytes(),
+    )
+}
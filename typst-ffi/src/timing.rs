@@ -0,0 +1,79 @@
+//! Per-phase compile timings and Chrome trace export.
+
+use std::time::Duration;
+
+use serde_json::json;
+use typst::comemo::Track;
+use typst::engine::{Route, Sink, Traced};
+use typst::syntax::Span;
+use typst::World;
+
+use crate::SingleSourceWorld;
+
+/// Wall-clock time spent in each phase of a compilation.
+#[derive(Default)]
+pub(crate) struct Timings {
+    /// Constructing the world: parsing the main source (with the preamble)
+    /// and resolving the root directory. Imported files are parsed during
+    /// evaluation.
+    pub parse: Duration,
+    /// Evaluating the main module, imports included.
+    pub eval: Duration,
+    /// `typst::compile`: layout and introspection. Its own evaluation of the
+    /// main module is a cache lookup, see [`eval_main`].
+    pub layout: Duration,
+    /// PDF export.
+    pub pdf: Duration,
+}
+
+impl Timings {
+    /// Durations in nanoseconds as JSON.
+    pub(crate) fn to_json(&self) -> String {
+        json!({
+            "parse": self.parse.as_nanos() as u64,
+            "eval": self.eval.as_nanos() as u64,
+            "layout": self.layout.as_nanos() as u64,
+            "pdf": self.pdf.as_nanos() as u64,
+        })
+        .to_string()
+    }
+}
+
+/// Evaluate the main source ahead of layout so that evaluation can be timed
+/// on its own; errors are reported by the compile. `typst_eval::eval` is
+/// memoized, and `typst::compile` calls it with the same arguments (the
+/// world, default `Traced` and `Route`, the main source), so the compile
+/// reuses this module instead of evaluating again. That holds as long as
+/// nothing evicts comemo's cache between the two calls; this crate never
+/// evicts it.
+pub(crate) fn eval_main(world: &SingleSourceWorld) {
+    let tracked: &dyn World = world;
+    let _ = typst_eval::eval(
+        &typst::ROUTINES,
+        tracked.track(),
+        Traced::default().track(),
+        Sink::new().track_mut(),
+        Route::default().track(),
+        &world.source,
+    );
+}
+
+/// Start recording timing spans. Recording is process-wide.
+pub(crate) fn start_trace() {
+    typst_timing::clear();
+    typst_timing::enable();
+}
+
+/// Stop recording and export the spans as Chrome trace-event JSON, with
+/// source locations resolved against the world.
+pub(crate) fn finish_trace(world: &SingleSourceWorld) -> Vec<u8> {
+    typst_timing::disable();
+    let mut out = Vec::new();
+    let _ = typst_timing::export_json(&mut out, |raw| match world.locate(Span::from_raw(raw)) {
+        Some(loc) if loc.path.is_empty() => ("<main>".to_string(), loc.line as u32),
+        Some(loc) => (loc.path, loc.line as u32),
+        None => ("unknown".to_string(), 0),
+    });
+    typst_timing::clear();
+    out
+}
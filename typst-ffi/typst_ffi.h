@@ -7,30 +7,192 @@
 // Opaque handle to a compiler instance.
 typedef struct TypstWorld TypstWorld;
 
+// Opaque handle to a compiled document.
+typedef struct TypstDocument TypstDocument;
+
+// Opaque handle to fonts shared by several compiler instances.
+typedef struct TypstFontSet TypstFontSet;
+
 typedef struct {
     uint8_t *data;
     size_t len;
-    int32_t error;  // 0 = success, 1 = error
+    int32_t error;       // 0 = success, 1 = error
+    TypstDocument *doc;  // on successful compilation, the document owning data
 } TypstResult;
 
-// Create a new compiler instance with optional custom fonts.
-// Bundled fonts are always included. Custom fonts are added on top.
-// Pass NULL/0 for no custom fonts.
+// A borrowed byte string. NULL/0 means unset.
+typedef struct {
+    const uint8_t *ptr;
+    size_t len;
+} TypstSlice;
+
+// An in-memory file made visible to the compilation at `path`.
+typedef struct {
+    TypstSlice path;
+    TypstSlice data;
+} TypstFile;
+
+// A directory holding the packages of one namespace as {name}/{version}.
+typedef struct {
+    TypstSlice namespace;
+    TypstSlice dir;
+} TypstPackageNamespace;
+
+// Per-compilation options. All fields are optional (NULL/0 = unset).
+typedef struct {
+    TypstSlice root;          // root directory for local file resolution
+    const TypstSlice *package_paths; // package directories ({namespace}/{name}/{version}),
+    size_t package_path_count;       // searched in order
+    const TypstPackageNamespace *package_namespaces; // per-namespace directories, used
+    size_t package_namespace_count;                  // instead of package_paths
+    const TypstFile *files;   // in-memory files, resolved before the root directory
+    size_t file_count;
+    TypstSlice preamble;      // Typst markup prepended to the source
+    TypstSlice pdf_standards; // comma-separated PDF standards, e.g. "a-3b"
+    TypstSlice metadata;      // JSON object overriding document metadata
+    TypstSlice query;         // selector to query the document for, as in `typst query`
+    int32_t skip_pdf;         // nonzero: lay out only; the result carries no PDF bytes
+    int32_t trace;            // nonzero: record timing spans (process-wide) as a Chrome trace
+    int32_t keep_layout;      // nonzero: keep the layout for text, link and font inspection
+} TypstCompileOptions;
+
+// Options for creating a compiler instance. All fields are optional (NULL/0 = unset).
+typedef struct {
+    const TypstFile *fonts;   // custom font files (TTF/OTF/TTC/OTC), added on top of bundled
+                              // fonts; entries without data are read from disk at path
+    size_t font_count;
+    TypstSlice globals;       // JSON object whose keys become global Typst variables
+    TypstSlice styles;        // Typst set rules (code syntax) applied as default styles
+    int32_t no_bundled_fonts; // nonzero: load only the custom fonts
+    const TypstFontSet *font_set; // shared fonts used instead of the bundled fonts;
+                                  // `fonts` are added on top for this instance
+} TypstWorldOptions;
+
+// Load fonts once to share between compiler instances; entries without data
+// are read from disk at path. Bundled fonts are included unless
+// no_bundled_fonts is set. Free with typst_font_set_free.
+TypstFontSet *typst_font_set_new(const TypstFile *fonts, size_t count, int32_t no_bundled_fonts);
+
+// Warnings from loading a font set (e.g. skipped font files) as a JSON array
+// of strings. Free with typst_free_result.
+TypstResult typst_font_set_warnings(const TypstFontSet *set);
+
+// Release a font set. Compiler instances created with it keep their fonts.
+void typst_font_set_free(TypstFontSet *set);
+
+// Create a new compiler instance. Bundled fonts are included unless
+// no_bundled_fonts is set.
+// options may be NULL. On failure returns NULL and, if error is non-NULL,
+// stores a message in it (free with typst_free_result).
 // Returns a heap-allocated handle. Free with typst_world_free.
-TypstWorld *typst_world_new(const uint8_t **font_ptrs, const size_t *font_lens, size_t font_count);
+TypstWorld *typst_world_new(const TypstWorldOptions *options, TypstResult *error);
+
+// Warnings from creating a compiler instance (e.g. skipped font files) as a
+// JSON array of strings. Free with typst_free_result.
+TypstResult typst_world_warnings(const TypstWorld *world);
+
+// The fonts of a compiler instance (family, variant, axes, coverage ranges and
+// origin) as a JSON array. Free with typst_free_result.
+TypstResult typst_world_fonts(const TypstWorld *world);
+
+// 1 if the compiler has a font of the family (case-insensitive), else 0.
+int32_t typst_world_has_font(const TypstWorld *world, TypstSlice family);
+
+// Add font files; files without data are read from disk at their path.
+// Compilations already running keep their fonts. Returns the warnings for
+// skipped files as a JSON array of strings.
+TypstResult typst_world_add_fonts(const TypstWorld *world, const TypstFile *fonts, size_t count);
+
+// Remove every face of the given families (case-insensitive). Compilations
+// already running keep their fonts. Returns the number of faces removed.
+size_t typst_world_remove_fonts(const TypstWorld *world, const TypstSlice *families, size_t count);
 
 // Compile a Typst source string to PDF.
-// root_ptr/root_len: optional root directory for local file resolution (NULL/0 = disabled).
-// pkg_ptr/pkg_len: optional package cache directory (NULL/0 = disabled).
+// options may be NULL; its slices only need to stay valid for the call.
+// On success, data/len is the PDF owned by doc (empty with skip_pdf); free it
+// with typst_document_free.
+// On error, data holds a JSON object {"message": ..., "diagnostics": [...]}.
 TypstResult typst_world_compile(const TypstWorld *world,
     const uint8_t *source_ptr, size_t source_len,
-    const uint8_t *root_ptr, size_t root_len,
-    const uint8_t *pkg_ptr, size_t pkg_len);
+    const TypstCompileOptions *options);
+
+// Evaluate Typst code (code syntax, like `typst eval`) and return its value as
+// JSON. None, booleans, numbers, strings and arrays map directly; any other
+// value is a one-key object naming its kind: {"dict": {...}} (also for
+// modules), {"datetime": "..."}, {"length": {"pt": ..., "em": ...}} or
+// {"other": ...} with Typst's own serialization.
+// options may be NULL; only root, the package directories and files are used.
+// On error, data holds a JSON object like typst_world_compile's.
+// Free the result with typst_free_result.
+TypstResult typst_world_eval(const TypstWorld *world,
+    const uint8_t *code_ptr, size_t code_len,
+    const TypstCompileOptions *options);
 
 // Free a compiler instance.
 void typst_world_free(TypstWorld *world);
 
-// Free memory returned by typst_world_compile.
+// Free memory of a result without a doc (errors and document accessors).
 void typst_free_result(uint8_t *data, size_t len);
 
+// Parse a Typst source and return the string paths of its import and include
+// expressions as a JSON object: "packages" (package specs) and "files" (other
+// paths). Sets error on invalid UTF-8. Free with typst_free_result.
+TypstResult typst_source_imports(TypstSlice source);
+
+// Parse a typst.toml like a package import does and return its [package]
+// table as a JSON object. If spec ("@namespace/name:version") is given, the
+// manifest is checked against it and the problem, if any, is in "problem".
+// Sets error if the manifest does not parse. Free with typst_free_result.
+TypstResult typst_package_manifest(TypstSlice manifest, TypstSlice spec);
+
+// Effective document metadata as a JSON object. Free with typst_free_result.
+TypstResult typst_document_metadata(const TypstDocument *doc);
+
+// Results of the compile-time query as a JSON array, or null if no query was
+// given. Free with typst_free_result.
+TypstResult typst_document_query(const TypstDocument *doc);
+
+// Page sizes (in points), numbers and labels as a JSON array. Free with typst_free_result.
+TypstResult typst_document_pages(const TypstDocument *doc);
+
+// Headings in document order as a JSON array. Free with typst_free_result.
+TypstResult typst_document_outline(const TypstDocument *doc);
+
+// Plain text of the page at a 0-based index, in reading order.
+// Sets error if the page is out of range or the layout was not kept
+// (keep_layout). Free with typst_free_result.
+TypstResult typst_document_text(const TypstDocument *doc, size_t page);
+
+// Positions of the elements carrying a label (without angle brackets) as a
+// JSON array. Sets error if the layout was not kept. Free with
+// typst_free_result.
+TypstResult typst_document_locate(const TypstDocument *doc, TypstSlice label);
+
+// Fonts used by text, with family, style, weight and glyph count, as a JSON
+// array. Sets error if the layout was not kept. Free with typst_free_result.
+TypstResult typst_document_fonts(const TypstDocument *doc);
+
+// Code points no font could render, with page and source location, as a JSON
+// array, found by walking the layout on each call. Sets error if the layout
+// was not kept. Free with typst_free_result.
+TypstResult typst_document_missing_glyphs(const TypstDocument *doc);
+
+// Per-phase compile durations in nanoseconds as a JSON object with parse, eval,
+// layout and pdf. Free with typst_free_result.
+TypstResult typst_document_timings(const TypstDocument *doc);
+
+// Chrome trace-event JSON recorded with trace set; empty otherwise.
+// Free with typst_free_result.
+TypstResult typst_document_trace(const TypstDocument *doc);
+
+// Link areas and destinations as a JSON array. Sets error if the layout was
+// not kept. Free with typst_free_result.
+TypstResult typst_document_links(const TypstDocument *doc);
+
+// Number of pages.
+size_t typst_document_page_count(const TypstDocument *doc);
+
+// Free a compiled document, including its PDF bytes.
+void typst_document_free(TypstDocument *doc);
+
 #endif // TYPST_FFI_H
@@ -19,12 +19,15 @@ package typst
 import "C"
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"strings"
 	"sync"
 	"unsafe"
 )
@@ -33,8 +36,35 @@ import (
 type CompileOption func(*compileConfig)
 
 type compileConfig struct {
-	root       string // directory for resolving #import and #image paths
-	packageDir string // directory for resolving @preview/... package imports
+	root     string        // directory for resolving #import and #image paths
+	files    []virtualFile // in-memory files, resolved before root
+	preamble []string      // Typst markup prepended to the source
+
+	packagePaths    []string          // package directories, searched in order
+	packagePathsSet bool              // packagePaths was given; otherwise the defaults apply
+	namespaces      map[string]string // per-namespace package directories
+	registry        string            // registry to download missing packages from; empty disables downloads
+
+	pdfStandards []PDFStandard // standards the PDF export must comply with
+	metadata     *Metadata     // document metadata overrides
+	attachments  []attachment  // files to embed in the PDF
+
+	query    string             // selector to query the laid-out document for
+	queryOut *[]json.RawMessage // receives the query results
+	skipPDF  bool               // lay out only, without PDF export
+	layout   bool               // keep the layout for Text, Locate, Links, FontsUsed, ...
+
+	noTemplateCheck bool // NewTemplate skips compiling with a sample value
+
+	trace io.Writer // receives the Chrome trace of the compilation
+
+	err error // first invalid option, returned by the compilation
+}
+
+// virtualFile is an in-memory file supplied with [WithFile].
+type virtualFile struct {
+	path string
+	data []byte
 }
 
 // WithRoot sets the root directory for resolving local file imports and images.
@@ -46,11 +76,48 @@ func WithRoot(dir string) CompileOption {
 	}
 }
 
-// WithPackageDir overrides the default package cache directory.
-// Typst packages are resolved at {dir}/{namespace}/{name}/{version}/.
+// WithPackageDir makes dir the only package directory, replacing the
+// default ones. It is short for WithPackagePaths(dir).
 func WithPackageDir(dir string) CompileOption {
+	return WithPackagePaths(dir)
+}
+
+// WithPackagePaths sets the package directories, replacing the default ones
+// ([DefaultPackageDataDir], then [DefaultPackageDir]). A package
+// @namespace/name:version is loaded from {dir}/{namespace}/{name}/{version}
+// of the first directory that has it, so a project-local directory listed
+// first overrides the shared ones. Downloaded packages go to the last
+// directory. Namespaces mapped with [WithPackageNamespace] are not searched
+// here.
+func WithPackagePaths(dirs ...string) CompileOption {
+	return func(cfg *compileConfig) {
+		cfg.packagePaths = dirs
+		cfg.packagePathsSet = true
+	}
+}
+
+// WithPackageNamespace loads the packages of namespace (with or without the
+// leading "@") from dir, laid out as {dir}/{name}/{version}, instead of the
+// package directories. It suits a namespace kept in its own repository:
+//
+//	typst.WithPackageNamespace("acme", "/src/acme-typst-packages")
+func WithPackageNamespace(namespace, dir string) CompileOption {
+	return func(cfg *compileConfig) {
+		if cfg.namespaces == nil {
+			cfg.namespaces = make(map[string]string)
+		}
+		cfg.namespaces[strings.TrimPrefix(namespace, "@")] = dir
+	}
+}
+
+// WithFile makes data available to the document at path, as if it were a
+// file under the root directory. Paths are absolute within the project
+// ("/data.json" and "data.json" are the same file). In-memory files take
+// precedence over files on disk and work without [WithRoot].
+// The data slice is not retained after the compile call.
+func WithFile(path string, data []byte) CompileOption {
 	return func(cfg *compileConfig) {
-		cfg.packageDir = dir
+		cfg.files = append(cfg.files, virtualFile{path: path, data: data})
 	}
 }
 
@@ -83,23 +150,176 @@ func DefaultPackageDir() string {
 	return defaultPkgDir.dir
 }
 
+var defaultPkgDataDir struct {
+	once sync.Once
+	dir  string
+}
+
+// DefaultPackageDataDir returns the platform-specific Typst package data
+// directory, where the typst CLI looks for locally installed packages
+// (e.g. @local) before the cache.
+// On Linux: ~/.local/share/typst/packages/
+// On macOS: ~/Library/Application Support/typst/packages/
+// The result is computed once and cached for the lifetime of the process.
+func DefaultPackageDataDir() string {
+	defaultPkgDataDir.once.Do(func() {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return
+		}
+		if runtime.GOOS == "darwin" {
+			defaultPkgDataDir.dir = filepath.Join(home, "Library", "Application Support", "typst", "packages")
+			return
+		}
+		// Linux and others: respect XDG_DATA_HOME if set.
+		if dataDir := os.Getenv("XDG_DATA_HOME"); dataDir != "" {
+			defaultPkgDataDir.dir = filepath.Join(dataDir, "typst", "packages")
+			return
+		}
+		defaultPkgDataDir.dir = filepath.Join(home, ".local", "share", "typst", "packages")
+	})
+	return defaultPkgDataDir.dir
+}
+
 // CompileError represents a Typst compilation error.
 type CompileError struct {
 	Message string
+
+	// Diagnostics holds the individual errors and warnings, with positions.
+	// It is empty for errors raised before compilation, such as empty source.
+	Diagnostics []Diagnostic
+
+	// MissingPackages lists the imported packages that were not found in
+	// the package directory. See [WithPackageDownload].
+	MissingPackages []PackageSpec
+
+	trace string // Chrome trace of the failed compilation, if WithTrace was given
 }
 
 func (e *CompileError) Error() string {
 	return e.Message
 }
 
+// Diagnostic is a single compiler error or warning.
+type Diagnostic struct {
+	Severity string   `json:"severity"` // "error" or "warning"
+	Message  string   `json:"message"`
+	Hints    []string `json:"hints"`
+
+	// Path is the file the diagnostic points into: empty for the compiled
+	// source (the file path for CompileFile), root-relative for imported
+	// files, "@namespace/name:version/file" for package files, and
+	// "<preamble>" for [WithPreamble] markup.
+	Path string `json:"path"`
+
+	// Line and Column are 1-based; Column counts characters. Both are zero
+	// when the diagnostic has no source position. Lines in the compiled
+	// source are not shifted by any preamble.
+	Line   int `json:"line"`
+	Column int `json:"column"`
+
+	citationKey string // key of the failed citation, see [Diagnostic.CitationKey]
+}
+
+// newCompileError decodes the JSON error payload of a compile result.
+func newCompileError(payload string) *CompileError {
+	var ce CompileError
+	var wire struct {
+		Message     string `json:"message"`
+		Diagnostics []struct {
+			Diagnostic
+			CitationKey string `json:"citation_key"`
+		} `json:"diagnostics"`
+		MissingPackages []string `json:"missing_packages"`
+		Trace           string   `json:"trace"`
+	}
+	if err := json.Unmarshal([]byte(payload), &wire); err != nil {
+		ce.Message = payload
+		return &ce
+	}
+	ce.Message = wire.Message
+	ce.trace = wire.Trace
+	for _, d := range wire.Diagnostics {
+		d.Diagnostic.citationKey = d.CitationKey
+		ce.Diagnostics = append(ce.Diagnostics, d.Diagnostic)
+	}
+	for _, s := range wire.MissingPackages {
+		if spec, ok := parsePackageSpec(s); ok {
+			ce.MissingPackages = append(ce.MissingPackages, spec)
+		}
+	}
+	return &ce
+}
+
 // Compiler is an independent Typst compiler instance with its own fonts
 // and internal caches. It is safe for concurrent use from multiple goroutines.
 //
 // Create with [New] and free with [Compiler.Close].
 type Compiler struct {
-	world  *C.TypstWorld // pointer to Rust-allocated SharedResources (fonts + stdlib)
-	once   sync.Once     // ensures free() runs at most once
-	closed bool          // prevents compile after Close
+	world    *C.TypstWorld // pointer to Rust-allocated SharedResources (fonts + stdlib)
+	once     sync.Once     // ensures free() runs at most once
+	life     sync.RWMutex  // read-held while world is in use; free() takes it to write closed
+	closed   bool          // prevents compile after Close; guarded by life
+	mu       sync.Mutex    // guards warnings
+	warnings []string      // problems found while loading fonts, e.g. skipped files
+}
+
+// CompilerOption configures a [Compiler] at construction.
+type CompilerOption func(*compilerConfig)
+
+type compilerConfig struct {
+	fonts   []fontFile     // custom font files, loaded on top of the bundled fonts
+	fontFS  []fontGlob     // fs.FS fonts, read at construction
+	globals map[string]any // global Typst variables, encoded as JSON
+	styles  []string       // set rules applied as default styles
+
+	fontSet        *FontSet // shared fonts, used instead of the bundled fonts
+	noBundledFonts bool     // leave out the bundled fonts
+	fallback       *bool    // whether font fallback is allowed; nil keeps Typst's default
+	warnings       []string // problems found while collecting fonts
+}
+
+// WithFonts adds font files (TTF/OTF) on top of the bundled fonts.
+// The font bytes are copied; the slices are not retained. For large fonts,
+// prefer [WithFontFiles], which maps the files instead of copying them.
+func WithFonts(fonts ...[]byte) CompilerOption {
+	return func(cfg *compilerConfig) {
+		for _, data := range fonts {
+			if len(data) == 0 {
+				cfg.warnings = append(cfg.warnings, "skipping font: empty data")
+				continue
+			}
+			cfg.fonts = append(cfg.fonts, fontFile{data: data})
+		}
+	}
+}
+
+// WithGlobal defines a global Typst variable visible to every compilation,
+// as if each document started with #let name = value.
+//
+// The value is converted through its JSON encoding: objects become
+// dictionaries, arrays become arrays, and numbers become int or float.
+func WithGlobal(name string, value any) CompilerOption {
+	return func(cfg *compilerConfig) {
+		if cfg.globals == nil {
+			cfg.globals = make(map[string]any)
+		}
+		cfg.globals[name] = value
+	}
+}
+
+// WithStyles applies Typst set rules as default styles for every
+// compilation, for example:
+//
+//	typst.WithStyles(`#set text(font: "Inter", lang: "de")`)
+//
+// The rules are evaluated once, at construction, and may refer to variables
+// defined with [WithGlobal]. Only set rules are allowed; documents can still
+// override them with their own set rules.
+func WithStyles(rules string) CompilerOption {
+	return func(cfg *compilerConfig) {
+		cfg.styles = append(cfg.styles, rules)
+	}
 }
 
 // New creates a new Compiler. Bundled fonts (Libertinus Serif,
@@ -109,45 +329,87 @@ type Compiler struct {
 // Multiple Compilers are fully independent — different fonts, no shared
 // locks, no contention.
 func New(fonts ...[]byte) (*Compiler, error) {
-	var world *C.TypstWorld
-
-	if len(fonts) == 0 {
-		world = C.typst_world_new(nil, nil, 0)
-	} else {
-		n := len(fonts)
-		// Allocate C arrays to pass font pointers and lengths across the FFI boundary.
-		cPtrs := (*[1 << 30]*C.uint8_t)(C.malloc(C.size_t(n) * C.size_t(unsafe.Sizeof((*C.uint8_t)(nil)))))[:n:n]
-		cLens := (*[1 << 30]C.size_t)(C.malloc(C.size_t(n) * C.size_t(unsafe.Sizeof(C.size_t(0)))))[:n:n]
-		defer C.free(unsafe.Pointer(&cPtrs[0]))
-		defer C.free(unsafe.Pointer(&cLens[0]))
-
-		for i, f := range fonts {
-			if len(f) == 0 {
-				cPtrs[i] = nil
-				cLens[i] = 0
-				continue
-			}
-			cPtrs[i] = (*C.uint8_t)(unsafe.Pointer(&f[0]))
-			cLens[i] = C.size_t(len(f))
-		}
+	return NewCompiler(WithFonts(fonts...))
+}
 
-		world = C.typst_world_new(
-			(**C.uint8_t)(unsafe.Pointer(&cPtrs[0])),
-			(*C.size_t)(unsafe.Pointer(&cLens[0])),
-			C.size_t(n),
-		)
+// NewCompiler creates a new Compiler configured by opts.
+// Bundled fonts are loaded as with [New], unless [WithoutBundledFonts] or
+// [WithFontSet] is given.
+func NewCompiler(opts ...CompilerOption) (*Compiler, error) {
+	var cfg compilerConfig
+	for _, o := range opts {
+		o(&cfg)
+	}
+
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+
+	warnings, err := cfg.readFontFS()
+	if err != nil {
+		return nil, err
+	}
+
+	var copts C.TypstWorldOptions
+	copts.fonts, copts.font_count = cFontFiles(&pinner, cfg.fonts)
+	if len(cfg.globals) > 0 {
+		globals, err := json.Marshal(cfg.globals)
+		if err != nil {
+			return nil, fmt.Errorf("encoding globals: %w", err)
+		}
+		copts.globals = cBytes(&pinner, globals)
+	}
+	styles := cfg.styles
+	if cfg.fallback != nil {
+		styles = append(slices.Clip(styles), fmt.Sprintf("set text(fallback: %t)", *cfg.fallback))
+	}
+	if len(styles) > 0 {
+		copts.styles = cString(&pinner, styleRules(styles))
+	}
+	if cfg.noBundledFonts {
+		copts.no_bundled_fonts = 1
+	}
+	if cfg.fontSet != nil {
+		if !cfg.fontSet.acquire() {
+			return nil, errors.New("typst: font set is closed")
+		}
+		defer cfg.fontSet.release()
+		copts.font_set = cfg.fontSet.handle
 	}
 
+	var cerr C.TypstResult
+	world := C.typst_world_new(&copts, &cerr)
 	if world == nil {
+		if cerr.error != 0 {
+			return nil, &CompileError{Message: takeString(cerr)}
+		}
 		return nil, errors.New("typst: failed to create compiler")
 	}
 
-	c := &Compiler{world: world}
+	var rustWarnings []string
+	if err := json.Unmarshal(takeBytes(C.typst_world_warnings(world)), &rustWarnings); err == nil {
+		warnings = append(warnings, rustWarnings...)
+	}
+
+	c := &Compiler{world: world, warnings: warnings}
 	// Safety net: if caller forgets Close(), the GC will eventually free Rust memory.
 	runtime.SetFinalizer(c, (*Compiler).free)
 	return c, nil
 }
 
+// styleRules joins rules into Typst code, dropping the leading "#" of
+// markup-style set rules.
+func styleRules(rules []string) string {
+	var b strings.Builder
+	for _, r := range rules {
+		for line := range strings.Lines(r) {
+			trimmed := strings.TrimLeft(line, " \t")
+			b.WriteString(strings.TrimPrefix(trimmed, "#"))
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
 // Compile reads Typst source from r and compiles it into a PDF.
 // The returned [Document] directly references the compiled PDF in
 // Rust-allocated memory with no copy. Call [Document.Close] when done.
@@ -185,77 +447,220 @@ func (c *Compiler) CompileFile(path string, opts ...CompileOption) (*Document, e
 	allOpts = append(allOpts, WithRoot(dir))
 	allOpts = append(allOpts, opts...)
 
-	return c.compile(source, allOpts)
+	doc, err := c.compile(source, allOpts)
+	if ce, ok := err.(*CompileError); ok {
+		// Name the file in diagnostics that point into the compiled source.
+		for i := range ce.Diagnostics {
+			if d := &ce.Diagnostics[i]; d.Path == "" && d.Line > 0 {
+				d.Path = absPath
+			}
+		}
+	}
+	return doc, err
 }
 
 // compile is the shared implementation for Compile, CompileBytes, and CompileFile.
 func (c *Compiler) compile(source []byte, opts []CompileOption) (*Document, error) {
-	if c.closed {
+	if !c.acquire() {
 		return nil, errors.New("typst: compiler is closed")
 	}
+	defer c.release()
 	if len(source) == 0 {
 		return nil, &CompileError{Message: "empty source"}
 	}
 
+	cfg := newCompileConfig(opts)
+
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+
+	copts, err := cfg.cOptions(&pinner)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := cfg.run(func() C.TypstResult {
+		if cfg.trace != nil {
+			// Typst records timing spans process-wide; one trace at a time.
+			traceMu.Lock()
+			defer traceMu.Unlock()
+		}
+		return C.typst_world_compile(
+			c.world,
+			(*C.uint8_t)(unsafe.Pointer(&source[0])),
+			C.size_t(len(source)),
+			&copts,
+		)
+	})
+	if err != nil {
+		var ce *CompileError
+		if cfg.trace != nil && errors.As(err, &ce) && ce.trace != "" {
+			if _, werr := io.WriteString(cfg.trace, ce.trace); werr != nil {
+				return nil, errors.Join(err, fmt.Errorf("typst: writing trace: %w", werr))
+			}
+		}
+		return nil, err
+	}
+
+	// Wrap the Rust-allocated PDF pointer in a Document; finalizer guards against leak.
+	doc := &Document{
+		data:   result.data,
+		len:    result.len,
+		handle: result.doc,
+	}
+	runtime.SetFinalizer(doc, (*Document).free)
+	if cfg.queryOut != nil && !doc.queryResults(cfg.queryOut) {
+		doc.Close()
+		return nil, errors.New("typst: decoding query results")
+	}
+	if cfg.trace != nil {
+		if err := doc.writeTrace(cfg.trace); err != nil {
+			doc.Close()
+			return nil, fmt.Errorf("typst: writing trace: %w", err)
+		}
+	}
+	return doc, nil
+}
+
+// newCompileConfig applies opts and fills in defaults.
+func newCompileConfig(opts []CompileOption) *compileConfig {
 	var cfg compileConfig
 	for _, o := range opts {
 		o(&cfg)
 	}
 
-	// Auto-detect default package dir if not explicitly set.
-	if cfg.packageDir == "" {
-		if dir := DefaultPackageDir(); dir != "" {
-			if info, err := os.Stat(dir); err == nil && info.IsDir() {
-				cfg.packageDir = dir
+	// Search the data and cache directories by default, like the typst CLI.
+	// Missing ones are skipped; with downloads enabled, the cache directory
+	// is created on first download.
+	if !cfg.packagePathsSet {
+		for _, dir := range []string{DefaultPackageDataDir(), DefaultPackageDir()} {
+			if dir != "" {
+				cfg.packagePaths = append(cfg.packagePaths, dir)
 			}
 		}
 	}
+	cfg.attach()
+	return &cfg
+}
 
-	// Prepare C pointers for root.
-	// Use unsafe.StringData to avoid []byte(string) copy allocations —
-	// the Rust side only reads these during the call.
-	var rootPtr *C.uint8_t
-	var rootLen C.size_t
-	if cfg.root != "" {
-		rootPtr = (*C.uint8_t)(unsafe.Pointer(unsafe.StringData(cfg.root)))
-		rootLen = C.size_t(len(cfg.root))
+// cOptions converts cfg to its C form. Referenced Go memory is pinned with
+// pinner and must stay pinned until the Rust call returns.
+func (cfg *compileConfig) cOptions(pinner *runtime.Pinner) (C.TypstCompileOptions, error) {
+	var copts C.TypstCompileOptions
+	if cfg.err != nil {
+		return copts, cfg.err
 	}
-
-	// Prepare C pointers for package dir.
-	var pkgPtr *C.uint8_t
-	var pkgLen C.size_t
-	if cfg.packageDir != "" {
-		pkgPtr = (*C.uint8_t)(unsafe.Pointer(unsafe.StringData(cfg.packageDir)))
-		pkgLen = C.size_t(len(cfg.packageDir))
+	copts.root = cString(pinner, cfg.root)
+	if len(cfg.packagePaths) > 0 {
+		paths := make([]C.TypstSlice, len(cfg.packagePaths))
+		for i, dir := range cfg.packagePaths {
+			paths[i] = cString(pinner, dir)
+		}
+		pinner.Pin(&paths[0])
+		copts.package_paths = &paths[0]
+		copts.package_path_count = C.size_t(len(paths))
+	}
+	if len(cfg.namespaces) > 0 {
+		namespaces := make([]C.TypstPackageNamespace, 0, len(cfg.namespaces))
+		for ns, dir := range cfg.namespaces {
+			namespaces = append(namespaces, C.TypstPackageNamespace{
+				namespace: cString(pinner, ns),
+				dir:       cString(pinner, dir),
+			})
+		}
+		pinner.Pin(&namespaces[0])
+		copts.package_namespaces = &namespaces[0]
+		copts.package_namespace_count = C.size_t(len(namespaces))
+	}
+	if len(cfg.files) > 0 {
+		files := make([]C.TypstFile, len(cfg.files))
+		for i, f := range cfg.files {
+			files[i].path = cString(pinner, f.path)
+			files[i].data = cBytes(pinner, f.data)
+		}
+		pinner.Pin(&files[0])
+		copts.files = &files[0]
+		copts.file_count = C.size_t(len(files))
+	}
+	if len(cfg.preamble) > 0 {
+		copts.preamble = cString(pinner, strings.Join(cfg.preamble, "\n"))
+	}
+	if len(cfg.pdfStandards) > 0 {
+		standards := make([]string, len(cfg.pdfStandards))
+		for i, s := range cfg.pdfStandards {
+			standards[i] = string(s)
+		}
+		copts.pdf_standards = cString(pinner, strings.Join(standards, ","))
+	}
+	if cfg.metadata != nil {
+		metadata, err := cfg.metadata.overrides()
+		if err != nil {
+			return copts, fmt.Errorf("encoding metadata: %w", err)
+		}
+		copts.metadata = cBytes(pinner, metadata)
+	}
+	copts.query = cString(pinner, cfg.query)
+	if cfg.skipPDF {
+		copts.skip_pdf = 1
 	}
+	if cfg.trace != nil {
+		copts.trace = 1
+	}
+	if cfg.layout {
+		copts.keep_layout = 1
+	}
+	return copts, nil
+}
+
+// takeBytes copies the data of a Rust result to Go memory and frees the
+// Rust-allocated buffer.
+func takeBytes(r C.TypstResult) []byte {
+	data := C.GoBytes(unsafe.Pointer(r.data), C.int(r.len))
+	C.typst_free_result(r.data, r.len)
+	return data
+}
 
-	result := C.typst_world_compile(
-		c.world,
-		(*C.uint8_t)(unsafe.Pointer(&source[0])),
-		C.size_t(len(source)),
-		rootPtr,
-		rootLen,
-		pkgPtr,
-		pkgLen,
-	)
+// takeString copies the message of a Rust result to Go memory and frees the
+// Rust-allocated buffer.
+func takeString(r C.TypstResult) string {
+	msg := C.GoStringN((*C.char)(unsafe.Pointer(r.data)), C.int(r.len))
+	C.typst_free_result(r.data, r.len)
+	return msg
+}
 
-	if result.error != 0 {
-		// Copy error message to Go memory and free the Rust-allocated buffer.
-		msg := C.GoBytes(unsafe.Pointer(result.data), C.int(result.len))
-		C.typst_free_result(result.data, result.len)
-		return nil, &CompileError{Message: string(msg)}
+// cString returns a C view of s without copying. The bytes are pinned with p
+// so the view may be stored in C-visible structs, such as an options struct;
+// p must stay pinned until the C call returns. The Rust side only reads
+// such views during the call and copies what it keeps. Empty strings yield
+// NULL/0.
+func cString(p *runtime.Pinner, s string) C.TypstSlice {
+	if s == "" {
+		return C.TypstSlice{}
 	}
+	ptr := unsafe.StringData(s)
+	p.Pin(ptr)
+	return C.TypstSlice{ptr: (*C.uint8_t)(unsafe.Pointer(ptr)), len: C.size_t(len(s))}
+}
 
-	// Wrap the Rust-allocated PDF pointer in a Document; finalizer guards against leak.
-	doc := &Document{
-		data: result.data,
-		len:  result.len,
+// cBytes is like cString for byte slices.
+func cBytes(p *runtime.Pinner, b []byte) C.TypstSlice {
+	if len(b) == 0 {
+		return C.TypstSlice{}
 	}
-	runtime.SetFinalizer(doc, (*Document).free)
-	return doc, nil
+	p.Pin(&b[0])
+	return C.TypstSlice{ptr: (*C.uint8_t)(unsafe.Pointer(&b[0])), len: C.size_t(len(b))}
+}
+
+// encodeData encodes a value built from strings, numbers, slices, maps and
+// plain structs as JSON. Encoding only fails for unsupported types such as
+// channels and functions, which such values cannot hold.
+func encodeData(v any) []byte {
+	data, _ := json.Marshal(v)
+	return data
 }
 
-// Close frees the compiler and all its internal resources.
+// Close frees the compiler and all its internal resources, after waiting
+// for calls already in progress (compilations, font changes, ...) to finish.
 // After Close, Compile/CompileBytes return errors.
 // Close is idempotent.
 func (c *Compiler) Close() error {
@@ -263,9 +668,28 @@ func (c *Compiler) Close() error {
 	return nil
 }
 
+// acquire marks a call into the Rust world as in progress, so that Close
+// waits for it. It reports false if the compiler is closed; otherwise the
+// caller must call release when done with the world.
+func (c *Compiler) acquire() bool {
+	c.life.RLock()
+	if c.closed {
+		c.life.RUnlock()
+		return false
+	}
+	return true
+}
+
+// release ends a call started with acquire.
+func (c *Compiler) release() {
+	c.life.RUnlock()
+}
+
 // free releases the Rust compiler. Idempotent via sync.Once.
 func (c *Compiler) free() {
 	c.once.Do(func() {
+		c.life.Lock()
+		defer c.life.Unlock()
 		if c.world != nil {
 			C.typst_world_free(c.world)
 		}
@@ -282,11 +706,12 @@ func (c *Compiler) free() {
 // the underlying memory. After Close, all methods return errors and
 // any byte slices previously returned by [Document.Bytes] are invalid.
 type Document struct {
-	data   *C.uint8_t // pointer to Rust-allocated PDF bytes
-	len    C.size_t   // size of the PDF in bytes
-	offset int        // current read position for io.Reader
-	once   sync.Once  // ensures free() runs at most once
-	closed bool       // prevents read/write after Close
+	data   *C.uint8_t       // pointer to Rust-allocated PDF bytes, owned by handle
+	len    C.size_t         // size of the PDF in bytes
+	handle *C.TypstDocument // Rust-side compiled document (layout + PDF)
+	offset int              // current read position for io.Reader
+	once   sync.Once        // ensures free() runs at most once
+	closed bool             // prevents read/write after Close
 }
 
 // Len returns the size of the PDF in bytes.
@@ -342,12 +767,13 @@ func (d *Document) Close() error {
 	return nil
 }
 
-// free releases the Rust-allocated PDF memory. Idempotent via sync.Once.
+// free releases the Rust-allocated document and PDF memory. Idempotent via sync.Once.
 func (d *Document) free() {
 	d.once.Do(func() {
-		if d.data != nil {
-			C.typst_free_result(d.data, d.len)
+		if d.handle != nil {
+			C.typst_document_free(d.handle)
 		}
+		d.handle = nil
 		d.data = nil
 		d.len = 0
 		d.closed = true
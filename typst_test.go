@@ -3,6 +3,7 @@ package typst
 import (
 	"bytes"
 	"io"
+	"os"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -226,6 +227,22 @@ func TestMultipleCompilers(t *testing.T) {
 	}
 }
 
+func TestCompileFile_diagnosticPath(t *testing.T) {
+	c := newTestCompiler(t)
+	path := filepath.Join(t.TempDir(), "broken.typ")
+	if err := os.WriteFile(path, []byte("Hello\n#let x = \n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := c.CompileFile(path)
+	var ce *CompileError
+	if !asCompileError(err, &ce) || len(ce.Diagnostics) == 0 {
+		t.Fatalf("expected CompileError with diagnostics, got %v", err)
+	}
+	if d := ce.Diagnostics[0]; d.Path != path || d.Line != 2 {
+		t.Fatalf("unexpected diagnostic: %+v", d)
+	}
+}
+
 func TestCompileFile(t *testing.T) {
 	c := newTestCompiler(t)
 	doc, err := c.CompileFile("testdata/sample.typ")
@@ -340,6 +357,53 @@ func TestPackage_CompileFile(t *testing.T) {
 	}
 }
 
+func TestWithFile(t *testing.T) {
+	c := newTestCompiler(t)
+	source := []byte(`#import "/lib.typ": shout
+#shout(read("notes.txt"))
+`)
+	doc, err := c.CompileBytes(source,
+		WithFile("/lib.typ", []byte(`#let shout(s) = upper(s)`)),
+		WithFile("notes.txt", []byte("in memory")),
+	)
+	if err != nil {
+		t.Fatalf("CompileBytes with in-memory files failed: %v", err)
+	}
+	defer doc.Close()
+
+	if !bytes.HasPrefix(doc.Bytes(), []byte("%PDF-")) {
+		t.Fatal("output does not look like a PDF")
+	}
+}
+
+func TestNewCompiler_globalsAndStyles(t *testing.T) {
+	c, err := NewCompiler(
+		WithGlobal("brand", map[string]any{"name": "ACME", "year": 2024}),
+		WithStyles(`#set text(lang: "de")
+#set page(paper: "a5")`),
+	)
+	if err != nil {
+		t.Fatalf("NewCompiler failed: %v", err)
+	}
+	defer c.Close()
+
+	doc, err := c.CompileBytes([]byte(`#assert.eq(brand.name, "ACME")
+#assert.eq(brand.year, 2024)
+#context assert.eq(text.lang, "de")
+Hello from #brand.name.`))
+	if err != nil {
+		t.Fatalf("compile with globals failed: %v", err)
+	}
+	defer doc.Close()
+}
+
+func TestNewCompiler_invalidStyles(t *testing.T) {
+	_, err := NewCompiler(WithStyles(`Hello`))
+	if err == nil {
+		t.Fatal("expected error for non-set-rule styles")
+	}
+}
+
 func asCompileError(err error, target **CompileError) bool {
 	if ce, ok := err.(*CompileError); ok {
 		*target = ce
@@ -0,0 +1,251 @@
+package typst
+
+/*
+#include "typst_ffi.h"
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// VendorManifest is the name of the manifest [VendorPackages] writes into
+// the destination directory.
+const VendorManifest = "vendor.json"
+
+// VendorPackages copies every package the document at entry depends on into
+// dest, so that compiling with WithPackageDir(dest) needs nothing else. It
+// follows the local files entry imports or includes, within the root
+// directory, and the packages imported by packages, starting from each
+// package's entrypoint; files a package does not reach from its entrypoint,
+// such as examples and tests, are not searched. Packages are taken from
+// the package directories, as for compiling ([WithPackagePaths],
+// [WithPackageNamespace]), and downloaded first if [WithPackageDownload] or
+// [WithPackageRegistry] is given; [WithRoot] sets the root, which defaults
+// to the directory of entry.
+//
+// Sources are parsed for import and include statements rather than
+// compiled, so imports in untaken branches are vendored too; imports of
+// paths computed at run time are not seen. A manifest listing the
+// packages is written to dest/vendor.json. The packages are returned sorted.
+func VendorPackages(entry, dest string, opts ...CompileOption) ([]PackageSpec, error) {
+	cfg := newCompileConfig(opts)
+	entry, err := filepath.Abs(entry)
+	if err != nil {
+		return nil, fmt.Errorf("resolving path: %w", err)
+	}
+	root := cfg.root
+	if root == "" {
+		root = filepath.Dir(entry)
+	}
+	if root, err = filepath.Abs(root); err != nil {
+		return nil, fmt.Errorf("resolving path: %w", err)
+	}
+
+	specs, err := localImports(entry, root)
+	if err != nil {
+		return nil, fmt.Errorf("typst: %w", err)
+	}
+
+	// Packages, followed through the packages they import.
+	dirs := make(map[PackageSpec]string)
+	for i := 0; i < len(specs); i++ {
+		spec := specs[i]
+		dir := cfg.findPackage(spec)
+		if dir == "" {
+			if cfg.registry == "" || !downloadable(spec) {
+				return nil, fmt.Errorf("typst: package %s not found in the package directories", spec)
+			}
+			dir = cfg.downloadDir(spec)
+			if err := downloadPackage(cfg.registry, dir, spec); err != nil {
+				return nil, err
+			}
+		}
+		dirs[spec] = dir
+		packages, err := packageImports(dir, spec)
+		if err != nil {
+			return nil, fmt.Errorf("typst: package %s: %w", spec, err)
+		}
+		specs = appendNew(specs, packages)
+	}
+
+	slices.SortFunc(specs, func(a, b PackageSpec) int { return strings.Compare(a.String(), b.String()) })
+	for _, spec := range specs {
+		if err := copyPackage(dirs[spec], dest, spec); err != nil {
+			return nil, fmt.Errorf("typst: package %s: %w", spec, err)
+		}
+	}
+	if err := writeVendorManifest(dest, specs); err != nil {
+		return nil, fmt.Errorf("typst: writing vendor manifest: %w", err)
+	}
+	return specs, nil
+}
+
+// localImports returns the packages imported by entry and by the .typ files
+// it imports or includes, transitively. Absolute paths resolve against root,
+// and files outside root are not followed.
+func localImports(entry, root string) ([]PackageSpec, error) {
+	var specs []PackageSpec
+	files := []string{entry}
+	seen := map[string]bool{entry: true}
+	for len(files) > 0 {
+		file := files[0]
+		files = files[1:]
+		data, err := os.ReadFile(file)
+		if err != nil {
+			if file == entry {
+				return nil, fmt.Errorf("reading typst file: %w", err)
+			}
+			continue // reported by the compiler, not needed for vendoring
+		}
+		packages, paths, err := sourceImports(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		specs = appendNew(specs, packages)
+		for _, path := range paths {
+			if filepath.Ext(path) != ".typ" {
+				continue
+			}
+			if strings.HasPrefix(path, "/") {
+				path = filepath.Join(root, path)
+			} else {
+				path = filepath.Join(filepath.Dir(file), path)
+			}
+			if rel, err := filepath.Rel(root, path); err != nil || !filepath.IsLocal(rel) || seen[path] {
+				continue
+			}
+			seen[path] = true
+			files = append(files, path)
+		}
+	}
+	return specs, nil
+}
+
+// packageImports returns the packages the package in dir imports from its
+// entrypoint and the files reachable from it. Within a package, absolute
+// paths resolve against the package directory, as they do when compiling.
+func packageImports(dir string, spec PackageSpec) ([]PackageSpec, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "typst.toml"))
+	if err != nil {
+		return nil, err
+	}
+	m, err := readManifest(data, spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid typst.toml: %w", err)
+	}
+	entry := filepath.FromSlash(m.Entrypoint)
+	if !filepath.IsLocal(entry) {
+		return nil, fmt.Errorf("entrypoint %s outside the package", m.Entrypoint)
+	}
+	return localImports(filepath.Join(dir, entry), dir)
+}
+
+// sourceImports parses a Typst source with Typst's parser and returns the
+// packages and the other paths its import and include statements name.
+func sourceImports(data []byte) ([]PackageSpec, []string, error) {
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+	result := C.typst_source_imports(cBytes(&pinner, data))
+	if result.error != 0 {
+		return nil, nil, errors.New(takeString(result))
+	}
+	var imports struct {
+		Packages []string `json:"packages"`
+		Files    []string `json:"files"`
+	}
+	if err := json.Unmarshal(takeBytes(result), &imports); err != nil {
+		return nil, nil, fmt.Errorf("decoding imports: %w", err)
+	}
+	var specs []PackageSpec
+	for _, s := range imports.Packages {
+		if spec, ok := parsePackageSpec(s); ok {
+			specs = append(specs, spec)
+		}
+	}
+	return specs, imports.Files, nil
+}
+
+// appendNew adds the specs in add that are not in specs yet.
+func appendNew(specs, add []PackageSpec) []PackageSpec {
+	for _, spec := range add {
+		if !slices.Contains(specs, spec) {
+			specs = append(specs, spec)
+		}
+	}
+	return specs
+}
+
+// copyPackage copies the package in from into the package directory dest,
+// replacing an earlier copy. The copy is made in a temporary directory and
+// renamed into place.
+func copyPackage(from, dest string, spec PackageSpec) error {
+	to := filepath.Join(dest, spec.Namespace, spec.Name, spec.Version)
+	if same, err := samePath(from, to); err != nil || same {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(to), ".vendor-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := os.CopyFS(tmp, os.DirFS(from)); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(to); err != nil {
+		return err
+	}
+	return renamePackage(tmp, to)
+}
+
+// samePath reports whether two paths name the same directory.
+func samePath(a, b string) (bool, error) {
+	ia, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	ib, err := os.Stat(b)
+	if err != nil {
+		return false, nil
+	}
+	return os.SameFile(ia, ib), nil
+}
+
+// writeVendorManifest writes the list of vendored packages to dest.
+func writeVendorManifest(dest string, specs []PackageSpec) error {
+	type entry struct {
+		Package   string `json:"package"`
+		Namespace string `json:"namespace"`
+		Name      string `json:"name"`
+		Version   string `json:"version"`
+		Path      string `json:"path"`
+	}
+	entries := make([]entry, len(specs))
+	for i, s := range specs {
+		entries[i] = entry{
+			Package:   s.String(),
+			Namespace: s.Namespace,
+			Name:      s.Name,
+			Version:   s.Version,
+			Path:      s.Namespace + "/" + s.Name + "/" + s.Version,
+		}
+	}
+	data, err := json.MarshalIndent(map[string]any{"packages": entries}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dest, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dest, VendorManifest), append(data, '\n'), 0o644)
+}
@@ -0,0 +1,106 @@
+package typst
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+// writeTestFile writes a file, creating its directory.
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVendorPackages(t *testing.T) {
+	// A project whose local helper imports package a, which imports b.
+	pkgDir := t.TempDir()
+	writePackage := func(name, lib string) {
+		dir := filepath.Join(pkgDir, "preview", name, "1.0.0")
+		writeTestFile(t, filepath.Join(dir, "typst.toml"), "[package]\nname = \""+name+"\"\nversion = \"1.0.0\"\nentrypoint = \"src/lib.typ\"\n")
+		writeTestFile(t, filepath.Join(dir, "src", "lib.typ"), lib)
+	}
+	writePackage("a", `#import "util.typ": b
+#let a() = [a #b()]`)
+	// Only files reached from the entrypoint are searched for imports.
+	writeTestFile(t, filepath.Join(pkgDir, "preview", "a", "1.0.0", "src", "util.typ"), `#import "@preview/b:1.0.0": b`)
+	writeTestFile(t, filepath.Join(pkgDir, "preview", "a", "1.0.0", "examples", "demo.typ"), `#import "@preview/absent:1.0.0"`)
+	writePackage("b", `#let b() = [b]`)
+	writePackage("unused", `#let unused() = []`)
+
+	project := t.TempDir()
+	writeTestFile(t, filepath.Join(project, "main.typ"), `#import "helper.typ": greet
+// #import "@preview/unused:1.0.0"
+#greet()`)
+	writeTestFile(t, filepath.Join(project, "helper.typ"), `#import "@preview/a:1.0.0": a
+#let note = "@preview/unused:1.0.0"
+#let greet() = a()`)
+
+	dest := filepath.Join(project, "vendor")
+	specs, err := VendorPackages(filepath.Join(project, "main.typ"), dest, WithPackageDir(pkgDir))
+	if err != nil {
+		t.Fatalf("VendorPackages failed: %v", err)
+	}
+	if len(specs) != 2 || specs[0].Name != "a" || specs[1].Name != "b" {
+		t.Fatalf("unexpected packages: %v", specs)
+	}
+	info, err := os.Stat(filepath.Join(dest, "preview", "a", "1.0.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0o755 {
+		t.Fatalf("vendored package has mode %v, want 0755", info.Mode().Perm())
+	}
+
+	var manifest struct {
+		Packages []struct {
+			Package string `json:"package"`
+			Path    string `json:"path"`
+		} `json:"packages"`
+	}
+	data, err := os.ReadFile(filepath.Join(dest, VendorManifest))
+	if err != nil {
+		t.Fatalf("no manifest: %v", err)
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil || len(manifest.Packages) != 2 ||
+		manifest.Packages[1].Package != "@preview/b:1.0.0" || manifest.Packages[1].Path != "preview/b/1.0.0" {
+		t.Fatalf("unexpected manifest: %s", data)
+	}
+
+	c := newTestCompiler(t)
+	doc, err := c.CompileFile(filepath.Join(project, "main.typ"), WithPackageDir(dest))
+	if err != nil {
+		t.Fatalf("CompileFile with vendored packages failed: %v", err)
+	}
+	doc.Close()
+
+	if _, err := VendorPackages(filepath.Join(project, "main.typ"), dest, WithPackageDir(t.TempDir())); err == nil {
+		t.Fatal("expected error for missing packages")
+	}
+}
+
+func TestSourceImports(t *testing.T) {
+	packages, files, err := sourceImports([]byte(`#import "@preview/a:1.0.0": x
+#if false { include "chapters/intro.typ" }
+// #import "@preview/comment:1.0.0"
+#let s = "@preview/string:1.0.0"
+#let y = { import "/util.typ"; 1 }
+#import "@preview/bad"`))
+	if err != nil {
+		t.Fatalf("sourceImports failed: %v", err)
+	}
+	if want := []PackageSpec{{"preview", "a", "1.0.0"}}; !reflect.DeepEqual(packages, want) {
+		t.Errorf("packages = %v, want %v", packages, want)
+	}
+	if want := []string{"chapters/intro.typ", "/util.typ"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("files = %q, want %q", files, want)
+	}
+}